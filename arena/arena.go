@@ -0,0 +1,330 @@
+package arena
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/ylt94/mycache/lru"
+)
+
+//基于环形字节缓冲区的存储
+//条目序列化后写入一整块 []byte 索引为不含指针的 map[uint64]uint64 GC 不需要扫描每个条目
+//淘汰采用 CLOCK(second chance) 近似 LRU: 被访问过的条目到达队头时移到队尾而不是淘汰
+//key 的 hash 冲突时后写入的 key 会淘汰先写入的 key
+//非并发安全 由调用方加锁
+
+//条目头: version 8 | key 长度 4 | value 长度 4 | flags 1
+const headerSize = 17
+
+var ErrTooLarge = errors.New("arena: entry larger than buffer")
+
+const (
+	flagDeleted = 1 << iota
+	flagAccessed
+)
+
+type Cache struct {
+	buf       []byte
+	head      uint64            //最早条目的绝对偏移 位置为 偏移 % len(buf)
+	tail      uint64            //下一个条目写入的绝对偏移
+	index     map[uint64]uint64 //key hash -> 条目偏移
+	usedBytes int64             //有效条目占用 不含已删除但尚未回收的空间
+	version   uint64
+	onRemoved func(key string) //数据被删除或淘汰时回调
+	onEvicted func(key string, value []byte, version uint64) //设置后淘汰时代替 onRemoved 调用
+	stats     lru.Stats
+	scratch   []byte
+}
+
+//maxBytes 为缓冲区大小 必须大于 0
+func New(maxBytes int64, onRemoved func(key string)) *Cache {
+	if maxBytes <= 0 {
+		panic("arena: maxBytes must be positive")
+	}
+	return &Cache{
+		buf:       make([]byte, maxBytes),
+		index:     make(map[uint64]uint64),
+		onRemoved: onRemoved,
+	}
+}
+
+//设置淘汰回调 value 为数据的拷贝 version 为淘汰时的版本号
+func (c *Cache) SetOnEvicted(fn func(key string, value []byte, version uint64)) {
+	c.onEvicted = fn
+}
+
+//淘汰回调 需在条目空间被覆盖前调用
+func (c *Cache) evicted(key string, off uint64, valLen uint32) {
+	if c.onEvicted != nil {
+		version, _, _, _ := c.header(off)
+		c.onEvicted(key, c.value(off, len(key), valLen), version)
+	} else if c.onRemoved != nil {
+		c.onRemoved(key)
+	}
+}
+
+//fnv-1a
+func hash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+//从绝对偏移处读取 可能跨越缓冲区末尾
+func (c *Cache) read(dst []byte, off uint64) {
+	pos := off % uint64(len(c.buf))
+	n := copy(dst, c.buf[pos:])
+	copy(dst[n:], c.buf)
+}
+
+func (c *Cache) write(off uint64, src []byte) {
+	pos := off % uint64(len(c.buf))
+	n := copy(c.buf[pos:], src)
+	copy(c.buf, src[n:])
+}
+
+func (c *Cache) writeString(off uint64, src string) {
+	pos := off % uint64(len(c.buf))
+	n := copy(c.buf[pos:], src)
+	copy(c.buf, src[n:])
+}
+
+func (c *Cache) byteAt(off uint64) *byte {
+	return &c.buf[off%uint64(len(c.buf))]
+}
+
+func (c *Cache) header(off uint64) (version uint64, keyLen uint32, valLen uint32, flags byte) {
+	var h [headerSize]byte
+	c.read(h[:], off)
+	return binary.LittleEndian.Uint64(h[0:]), binary.LittleEndian.Uint32(h[8:]), binary.LittleEndian.Uint32(h[12:]), h[16]
+}
+
+func (c *Cache) key(off uint64, keyLen uint32) string {
+	b := make([]byte, keyLen)
+	c.read(b, off+headerSize)
+	return string(b)
+}
+
+//比较条目的 key 不分配内存
+func (c *Cache) keyEqual(off uint64, keyLen uint32, key string) bool {
+	if int(keyLen) != len(key) {
+		return false
+	}
+	off += headerSize
+	if pos := off % uint64(len(c.buf)); pos+uint64(len(key)) <= uint64(len(c.buf)) {
+		return string(c.buf[pos:pos+uint64(len(key))]) == key
+	}
+	for i := 0; i < len(key); i++ {
+		if *c.byteAt(off + uint64(i)) != key[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//查找 key 对应条目的偏移
+func (c *Cache) lookup(key string) (off uint64, version uint64, valLen uint32, ok bool) {
+	off, ok = c.index[hash(key)]
+	if !ok {
+		return 0, 0, 0, false
+	}
+	version, keyLen, valLen, _ := c.header(off)
+	if !c.keyEqual(off, keyLen, key) {
+		return 0, 0, 0, false
+	}
+	return off, version, valLen, true
+}
+
+func (c *Cache) value(off uint64, keyLen int, valLen uint32) []byte {
+	b := make([]byte, valLen)
+	c.read(b, off+headerSize+uint64(keyLen))
+	return b
+}
+
+//获取数据的拷贝及版本号 并标记为最近访问
+func (c *Cache) Get(key string) (value []byte, version uint64, ok bool) {
+	c.stats.Gets++
+	off, version, valLen, ok := c.lookup(key)
+	if !ok {
+		c.stats.Misses++
+		return nil, 0, false
+	}
+	c.stats.Hits++
+	*c.byteAt(off + 16) |= flagAccessed
+	return c.value(off, len(key), valLen), version, true
+}
+
+//获取数据 不影响淘汰顺序
+func (c *Cache) Peek(key string) (value []byte, version uint64, ok bool) {
+	off, version, valLen, ok := c.lookup(key)
+	if !ok {
+		return nil, 0, false
+	}
+	return c.value(off, len(key), valLen), version, true
+}
+
+//能否写入 valueLen 字节的 value
+func (c *Cache) Fits(key string, valueLen int) bool {
+	return uint64(headerSize+len(key)+valueLen) <= uint64(len(c.buf))
+}
+
+//写入数据 返回新版本号 超过缓冲区大小时返回 ErrTooLarge 原有数据保留
+func (c *Cache) Set(key string, value []byte) (uint64, error) {
+	return c.set(key, value, 0)
+}
+
+//写入从下一级存储移回的数据 保留淘汰前的版本号
+func (c *Cache) SetWithVersion(key string, value []byte, version uint64) error {
+	_, err := c.set(key, value, version)
+	return err
+}
+
+//version 为 0 时分配新版本号
+func (c *Cache) set(key string, value []byte, version uint64) (uint64, error) {
+	if !c.Fits(key, len(value)) {
+		return 0, ErrTooLarge
+	}
+	size := uint64(headerSize + len(key) + len(value))
+	h := hash(key)
+	if off, ok := c.index[h]; ok {
+		_, keyLen, valLen, _ := c.header(off)
+		if c.keyEqual(off, keyLen, key) {
+			c.remove(off, h)
+		} else {
+			//hash 冲突 淘汰旧 key
+			old := c.key(off, keyLen)
+			c.remove(off, h)
+			c.stats.Evictions++
+			c.evicted(old, off, valLen)
+		}
+	}
+	for c.tail-c.head+size > uint64(len(c.buf)) {
+		c.advance(true)
+	}
+
+	if version == 0 {
+		c.version++
+		version = c.version
+	} else if version > c.version {
+		c.version = version
+	}
+	c.stats.Sets++
+	var hd [headerSize]byte
+	binary.LittleEndian.PutUint64(hd[0:], version)
+	binary.LittleEndian.PutUint32(hd[8:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(hd[12:], uint32(len(value)))
+	off := c.tail
+	c.write(off, hd[:])
+	c.writeString(off+headerSize, key)
+	c.write(off+headerSize+uint64(len(key)), value)
+	c.tail += size
+	c.index[h] = off
+	c.usedBytes += int64(size)
+	return version, nil
+}
+
+//标记删除 空间在队头经过时回收
+func (c *Cache) remove(off uint64, h uint64) int64 {
+	_, keyLen, valLen, _ := c.header(off)
+	*c.byteAt(off + 16) |= flagDeleted
+	delete(c.index, h)
+	size := int64(headerSize + keyLen + valLen)
+	c.usedBytes -= size
+	return size
+}
+
+func (c *Cache) Del(key string) bool {
+	off, _, _, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	c.remove(off, hash(key))
+	c.stats.Deletes++
+	if c.onRemoved != nil {
+		c.onRemoved(key)
+	}
+	return true
+}
+
+//处理队头的条目 返回淘汰的字节数 已删除的条目直接回收
+//secondChance 为 true 时最近访问过的条目清除标记后移到队尾
+func (c *Cache) advance(secondChance bool) int64 {
+	off := c.head
+	_, keyLen, valLen, flags := c.header(off)
+	size := uint64(headerSize + keyLen + valLen)
+	if flags&flagDeleted != 0 {
+		c.head += size
+		return 0
+	}
+	if secondChance && flags&flagAccessed != 0 {
+		if cap(c.scratch) < int(size) {
+			c.scratch = make([]byte, size)
+		}
+		entry := c.scratch[:size]
+		c.read(entry, off)
+		entry[16] &^= flagAccessed
+		c.head += size
+		//移动后已用空间不变 原位置与新位置重叠时也能正确写入
+		c.write(c.tail, entry)
+		c.index[hash(string(entry[headerSize:headerSize+keyLen]))] = c.tail
+		c.tail += size
+		return 0
+	}
+	key := c.key(off, keyLen)
+	c.head += size
+	if h := hash(key); c.index[h] == off {
+		delete(c.index, h)
+	}
+	c.usedBytes -= int64(size)
+	c.stats.Evictions++
+	//head 前移后数据在下次写入前仍然有效
+	c.evicted(key, off, valLen)
+	return int64(size)
+}
+
+//淘汰最早写入的有效条目 返回释放的内存 没有数据时返回 false
+func (c *Cache) RemoveOldest() (int64, bool) {
+	for c.head < c.tail {
+		if freed := c.advance(false); freed > 0 {
+			return freed, true
+		}
+	}
+	return 0, false
+}
+
+//返回所有 key 不保证顺序
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, len(c.index))
+	for _, off := range c.index {
+		_, keyLen, _, _ := c.header(off)
+		keys = append(keys, c.key(off, keyLen))
+	}
+	return keys
+}
+
+//当前数据条数
+func (c *Cache) Len() int {
+	return len(c.index)
+}
+
+//有效数据占用的内存
+func (c *Cache) UsedBytes() int64 {
+	return c.usedBytes
+}
+
+//缓冲区大小
+func (c *Cache) MaxBytes() int64 {
+	return int64(len(c.buf))
+}
+
+//返回统计信息快照
+func (c *Cache) Stats() lru.Stats {
+	s := c.stats
+	s.UsedBytes = c.usedBytes
+	s.MaxBytes = int64(len(c.buf))
+	s.Items = int64(len(c.index))
+	return s
+}
@@ -0,0 +1,258 @@
+package arena
+
+import (
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//与 lru 包的基准测试使用相同的数据量 go test -bench . ./arena ./lru
+const (
+	benchEntries   = 1000000
+	benchValueSize = 64
+)
+
+func benchKeys() []string {
+	keys := make([]string, benchEntries)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+//写满数据的缓存 缓冲区为数据量的两倍 不会触发淘汰
+func benchCache(keys []string, value []byte) *Cache {
+	c := New(int64(len(keys))*int64(headerSize+16+len(value))*2, nil)
+	for _, k := range keys {
+		c.Set(k, value)
+	}
+	return c
+}
+
+func BenchmarkSet(b *testing.B) {
+	keys, value := benchKeys(), make([]byte, benchValueSize)
+	c := benchCache(keys, value)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Set(keys[i%len(keys)], value)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	keys, value := benchKeys(), make([]byte, benchValueSize)
+	c := benchCache(keys, value)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkMixed(b *testing.B) {
+	keys, value := benchKeys(), make([]byte, benchValueSize)
+	c := benchCache(keys, value)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%10 == 0 {
+			c.Set(keys[i%len(keys)], value)
+		} else {
+			c.Get(keys[(i*7)%len(keys)])
+		}
+	}
+}
+
+//写满数据后一次完整 GC 的耗时
+func BenchmarkGC(b *testing.B) {
+	keys, value := benchKeys(), make([]byte, benchValueSize)
+	c := benchCache(keys, value)
+	runtime.GC()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	runtime.KeepAlive(c)
+}
+
+//条目大小为 20 字节 缓冲区恰好放下 3 个
+const testEntrySize = headerSize + 1 + 2
+
+func newTestCache(entries int) (*Cache, *[]string) {
+	var evicted []string
+	c := New(int64(entries*testEntrySize), nil)
+	c.SetOnEvicted(func(key string, value []byte, version uint64) {
+		evicted = append(evicted, key)
+	})
+	return c, &evicted
+}
+
+//CLOCK 淘汰 访问过的条目到达队头时移到队尾 标记清除后下次淘汰
+func TestSecondChance(t *testing.T) {
+	cases := []struct {
+		name     string
+		accessed string
+		sets     string
+		evicted  string
+	}{
+		{"fifo without access", "", "def", "abc"},
+		{"accessed head skipped", "a", "def", "bca"},
+		{"accessed middle skipped", "b", "def", "acd"},
+		{"all accessed", "abc", "d", "a"},
+		{"accessed twice counts once", "aa", "de", "bc"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, evicted := newTestCache(3)
+			for _, k := range "abc" {
+				c.Set(string(k), []byte("v"+string(k)))
+			}
+			for _, k := range tc.accessed {
+				c.Get(string(k))
+			}
+			for _, k := range tc.sets {
+				c.Set(string(k), []byte("v"+string(k)))
+			}
+			if got := strings.Join(*evicted, ""); got != tc.evicted {
+				t.Fatalf("evicted %q; want %q", got, tc.evicted)
+			}
+			//移动后的条目数据不变
+			for _, k := range "abcdef" {
+				key := string(k)
+				if v, _, ok := c.Peek(key); ok && string(v) != "v"+key {
+					t.Fatalf("Peek(%s) = %q; want v%s", key, v, key)
+				}
+			}
+			if c.UsedBytes() != int64(c.Len()*testEntrySize) {
+				t.Fatalf("UsedBytes = %d with %d entries", c.UsedBytes(), c.Len())
+			}
+		})
+	}
+}
+
+//RemoveOldest 不给访问过的条目第二次机会 跳过已删除的条目
+func TestRemoveOldest(t *testing.T) {
+	c, evicted := newTestCache(3)
+	for _, k := range "abc" {
+		c.Set(string(k), []byte("v"+string(k)))
+	}
+	c.Get("a")
+	c.Del("b")
+	for _, want := range []string{"a", "c"} {
+		if freed, ok := c.RemoveOldest(); !ok || freed != testEntrySize {
+			t.Fatalf("RemoveOldest = %d, %v; want %d, true", freed, ok, testEntrySize)
+		}
+		if last := (*evicted)[len(*evicted)-1]; last != want {
+			t.Fatalf("evicted %s; want %s", last, want)
+		}
+	}
+	if _, ok := c.RemoveOldest(); ok {
+		t.Fatal("RemoveOldest on empty cache = true")
+	}
+}
+
+//条目跨越缓冲区末尾时读写正确 与按淘汰回调维护的 map 对比
+func TestWraparound(t *testing.T) {
+	c := New(1000, nil)
+	model := make(map[string]string)
+	c.SetOnEvicted(func(key string, value []byte, version uint64) {
+		if model[key] != string(value) {
+			t.Fatalf("evicted %s = %q; want %q", key, value, model[key])
+		}
+		delete(model, key)
+	})
+	rnd := rand.New(rand.NewSource(1))
+	wrapped := 0
+	for i := 0; i < 5000; i++ {
+		key := "k" + strconv.Itoa(rnd.Intn(60))
+		switch rnd.Intn(10) {
+		case 0:
+			if c.Del(key) != (model[key] != "") {
+				t.Fatalf("Del(%s) disagrees with model", key)
+			}
+			delete(model, key)
+			continue
+		case 1, 2:
+			c.Get(key)
+			continue
+		}
+		value := strings.Repeat(string(rune('a'+i%26)), 1+rnd.Intn(80))
+		if (c.tail%1000)+uint64(headerSize+len(key)+len(value)) > 1000 {
+			wrapped++
+		}
+		if _, err := c.Set(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		model[key] = value
+
+		var used int64
+		for k, v := range model {
+			got, _, ok := c.Peek(k)
+			if !ok || string(got) != v {
+				t.Fatalf("step %d: Peek(%s) = %q, %v; want %q", i, k, got, ok, v)
+			}
+			used += int64(headerSize + len(k) + len(v))
+		}
+		if c.Len() != len(model) || c.UsedBytes() != used {
+			t.Fatalf("step %d: Len %d UsedBytes %d; want %d, %d", i, c.Len(), c.UsedBytes(), len(model), used)
+		}
+	}
+	if wrapped == 0 {
+		t.Fatal("no entry crossed the end of the buffer")
+	}
+}
+
+//hash 冲突时新 key 淘汰旧 key 查找不会返回另一个 key 的数据
+//fnv-1a 64 位的冲突难以构造 通过让两个 key 的索引指向同一个条目模拟
+func TestHashCollision(t *testing.T) {
+	c, evicted := newTestCache(3)
+	c.Set("a", []byte("va"))
+	//a 的条目占用了 b 的索引位置
+	off := c.index[hash("a")]
+	delete(c.index, hash("a"))
+	c.index[hash("b")] = off
+
+	if v, _, ok := c.Get("b"); ok {
+		t.Fatalf("Get(b) = %q; want miss instead of a's value", v)
+	}
+	if _, _, ok := c.Peek("b"); ok {
+		t.Fatal("Peek(b) returned a's entry")
+	}
+	if c.Del("b") {
+		t.Fatal("Del(b) removed a's entry")
+	}
+
+	if _, err := c.Set("b", []byte("vb")); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*evicted, []string{"a"}) {
+		t.Fatalf("evicted %v; want [a]", *evicted)
+	}
+	if v, _, ok := c.Get("b"); !ok || string(v) != "vb" {
+		t.Fatalf("Get(b) = %q, %v; want vb", v, ok)
+	}
+	if c.Len() != 1 || c.UsedBytes() != testEntrySize {
+		t.Fatalf("Len %d UsedBytes %d; want 1, %d", c.Len(), c.UsedBytes(), testEntrySize)
+	}
+	if s := c.Stats(); s.Evictions != 1 {
+		t.Fatalf("Evictions = %d; want 1", s.Evictions)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	c, _ := newTestCache(3)
+	c.Set("a", []byte("va"))
+	v1, _, _ := c.Peek("a")
+	if _, err := c.Set("a", make([]byte, 3*testEntrySize)); err != ErrTooLarge {
+		t.Fatalf("Set too large = %v; want ErrTooLarge", err)
+	}
+	if v, _, ok := c.Peek("a"); !ok || string(v) != string(v1) {
+		t.Fatalf("old value lost after rejected Set: %q, %v", v, ok)
+	}
+	if !c.Fits("a", 3*testEntrySize-headerSize-1) || c.Fits("a", 3*testEntrySize-headerSize) {
+		t.Fatal("Fits boundary wrong")
+	}
+}
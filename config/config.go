@@ -0,0 +1,228 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+//配置项 对应结构体中的一个字段
+type field struct {
+	path  string //json tag 以 . 连接的路径
+	flag  string
+	usage string
+	v     reflect.Value
+}
+
+//配置加载器 优先级: 默认值 < 配置文件 < 环境变量 < 命令行参数
+//结构体字段通过 json tag 命名 flag tag 指定命令行参数名(默认为路径) usage tag 为说明 secret tag 标记的字段打印时隐藏
+type Loader struct {
+	envPrefix string
+	fs        *flag.FlagSet
+	fields    []field
+	byPath    map[string]field
+	file      string
+	flags     map[string]string //命令行中设置过的参数
+}
+
+//target 必须是已填好默认值的结构体指针 envPrefix 如 MYCACHE_
+func NewLoader(target interface{}, fs *flag.FlagSet, envPrefix string) *Loader {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		panic("config: target must be a pointer to struct")
+	}
+	l := &Loader{
+		envPrefix: envPrefix,
+		fs:        fs,
+		fields:    collect(rv.Elem(), ""),
+		byPath:    make(map[string]field),
+		flags:     make(map[string]string),
+	}
+	fs.StringVar(&l.file, "config", "", "配置文件 json/yaml 也可通过环境变量 "+envPrefix+"CONFIG 指定")
+	for _, f := range l.fields {
+		l.byPath[f.path] = f
+		fs.Var(&flagValue{l: l, f: f, def: format(f.v)}, f.flag, f.usage)
+	}
+	return l
+}
+
+//解析命令行参数 并依次应用配置文件、环境变量、命令行参数
+func (l *Loader) Parse(args []string) error {
+	if err := l.fs.Parse(args); err != nil {
+		return err
+	}
+	file := l.file
+	if file == "" {
+		file = os.Getenv(l.envPrefix + "CONFIG")
+	}
+	if file != "" {
+		values, err := loadFile(file)
+		if err != nil {
+			return err
+		}
+		for _, kv := range values {
+			f, ok := l.byPath[kv.key]
+			if !ok {
+				return fmt.Errorf("config file %s: unknown key %s", file, kv.key)
+			}
+			if err := set(f.v, kv.value); err != nil {
+				return fmt.Errorf("config file %s: %s: %v", file, kv.key, err)
+			}
+		}
+	}
+	for _, f := range l.fields {
+		name := l.EnvName(f.path)
+		if s, ok := os.LookupEnv(name); ok {
+			if err := set(f.v, s); err != nil {
+				return fmt.Errorf("env %s: %v", name, err)
+			}
+		}
+	}
+	for _, f := range l.fields {
+		if s, ok := l.flags[f.flag]; ok {
+			if err := set(f.v, s); err != nil {
+				return fmt.Errorf("flag -%s: %v", f.flag, err)
+			}
+		}
+	}
+	return nil
+}
+
+//配置路径对应的环境变量名 如 heartbeat.interval -> MYCACHE_HEARTBEAT_INTERVAL
+func (l *Loader) EnvName(path string) string {
+	return l.envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+}
+
+//命令行参数的值先记录下来 在配置文件和环境变量之后应用
+type flagValue struct {
+	l   *Loader
+	f   field
+	def string
+}
+
+func (v *flagValue) String() string {
+	return v.def
+}
+
+func (v *flagValue) Set(s string) error {
+	//先校验格式 使错误能在参数解析时报告
+	if err := set(reflect.New(v.f.v.Type()).Elem(), s); err != nil {
+		return err
+	}
+	v.l.flags[v.f.flag] = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.f.v.Kind() == reflect.Bool
+}
+
+//遍历结构体字段 嵌入且无 json 名称的结构体字段展开到上一层
+func collect(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if sf.Anonymous && name == "" && fv.Kind() == reflect.Struct {
+			fields = append(fields, collect(fv, prefix)...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		path := prefix + name
+		if fv.Kind() == reflect.Struct {
+			fields = append(fields, collect(fv, path+".")...)
+			continue
+		}
+		flagName := sf.Tag.Get("flag")
+		if flagName == "" {
+			flagName = path
+		}
+		fields = append(fields, field{path: path, flag: flagName, usage: sf.Tag.Get("usage"), v: fv})
+	}
+	return fields
+}
+
+//按字段类型解析字符串 列表以逗号分隔
+func set(v reflect.Value, s string) error {
+	s = strings.TrimSpace(s)
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		items := make([]string, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+//配置文件中的一项 key 为以 . 连接的路径
+type keyValue struct {
+	key   string
+	value string
+}
+
+//读取配置文件 按扩展名选择 json 或 yaml
+func loadFile(path string) ([]keyValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []keyValue
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	default:
+		values, err = parseJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %v", path, err)
+	}
+	return values, nil
+}
+
+func parseJSON(data []byte) ([]keyValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	var values []keyValue
+	err := flatten("", m, &values)
+	return values, err
+}
+
+//嵌套对象展开为路径 数组以逗号连接
+func flatten(prefix string, m map[string]interface{}, values *[]keyValue) error {
+	for k, v := range m {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if err := flatten(key+".", v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				if _, ok := item.(map[string]interface{}); ok {
+					return fmt.Errorf("%s: objects in lists are not supported", key)
+				}
+				items[i] = fmt.Sprint(item)
+			}
+			*values = append(*values, keyValue{key, strings.Join(items, ",")})
+		case nil:
+		default:
+			*values = append(*values, keyValue{key, fmt.Sprint(v)})
+		}
+	}
+	return nil
+}
+
+//解析 yaml 子集: 以缩进表示的嵌套对象、标量、
+//  key:
+//    - item
+//以及 key: [a, b] 形式的列表
+func parseYAML(data []byte) ([]keyValue, error) {
+	type level struct {
+		indent int
+		prefix string
+	}
+	var values []keyValue
+	stack := []level{{indent: -1}}
+	var list *keyValue //正在读取的列表
+	listIndent := -1
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := stripComment(sc.Text())
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			continue
+		}
+		if strings.ContainsRune(text[:len(text)-len(strings.TrimLeft(text, " \t"))], '\t') {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", line)
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if list == nil || indent < listIndent {
+				return nil, fmt.Errorf("line %d: list item without key", line)
+			}
+			item := unquote(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if list.value != "" {
+				list.value += ","
+			}
+			list.value += item
+			continue
+		}
+		if list != nil && list.value != "" {
+			values = append(values, *list)
+		}
+		list = nil
+
+		i := strings.Index(trimmed, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key", line)
+		}
+		for indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		key := stack[len(stack)-1].prefix + unquote(strings.TrimSpace(trimmed[:i]))
+		rest := strings.TrimSpace(trimmed[i+1:])
+		switch {
+		case rest == "":
+			//下一层可能是对象也可能是列表
+			stack = append(stack, level{indent: indent, prefix: key + "."})
+			list = &keyValue{key: key}
+			listIndent = indent
+		case strings.HasPrefix(rest, "[") && strings.HasSuffix(rest, "]"):
+			items := make([]string, 0)
+			for _, item := range strings.Split(rest[1:len(rest)-1], ",") {
+				if item = unquote(strings.TrimSpace(item)); item != "" {
+					items = append(items, item)
+				}
+			}
+			values = append(values, keyValue{key, strings.Join(items, ",")})
+		default:
+			values = append(values, keyValue{key, unquote(rest)})
+		}
+	}
+	if list != nil && list.value != "" {
+		values = append(values, *list)
+	}
+	return values, sc.Err()
+}
+
+//读取 yaml 中 key 对应的列表 key 不存在时返回 nil
+func ParseYAMLList(data []byte, key string) ([]string, error) {
+	values, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range values {
+		if kv.key == key {
+			return strings.Split(kv.value, ","), nil
+		}
+	}
+	return nil, nil
+}
+
+//去掉注释 引号内的 # 保留
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
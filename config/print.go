@@ -0,0 +1,103 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const secretMask = "***"
+
+//以 json 输出配置 字段顺序与结构体一致 时间间隔输出为 1s 形式 带 secret tag 的字段输出为 ***
+func Print(w io.Writer, target interface{}) error {
+	var buf bytes.Buffer
+	if err := writeStruct(&buf, reflect.Indirect(reflect.ValueOf(target)), ""); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeStruct(buf *bytes.Buffer, v reflect.Value, indent string) error {
+	buf.WriteString("{")
+	first := true
+	var walk func(v reflect.Value) error
+	walk = func(v reflect.Value) error {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" && !sf.Anonymous {
+				continue
+			}
+			name := strings.Split(sf.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			fv := v.Field(i)
+			if sf.Anonymous && name == "" && fv.Kind() == reflect.Struct {
+				if err := walk(fv); err != nil {
+					return err
+				}
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(sf.Name)
+			}
+			if !first {
+				buf.WriteString(",")
+			}
+			first = false
+			buf.WriteString("\n" + indent + "  ")
+			key, _ := json.Marshal(name)
+			buf.Write(key)
+			buf.WriteString(": ")
+			if fv.Kind() == reflect.Struct {
+				if err := writeStruct(buf, fv, indent+"  "); err != nil {
+					return err
+				}
+				continue
+			}
+			var val interface{} = fv.Interface()
+			if sf.Tag.Get("secret") != "" {
+				val = mask(fv)
+			} else if fv.Type() == durationType {
+				val = time.Duration(fv.Int()).String()
+			} else if fv.Kind() == reflect.Slice && fv.IsNil() {
+				val = []string{}
+			}
+			b, err := json.Marshal(val)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+		}
+		return nil
+	}
+	if err := walk(v); err != nil {
+		return err
+	}
+	if !first {
+		buf.WriteString("\n" + indent)
+	}
+	buf.WriteString("}")
+	return nil
+}
+
+//隐藏密钥 只保留是否设置 切片保留元素个数
+func mask(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Slice {
+		masked := make([]string, v.Len())
+		for i := range masked {
+			masked[i] = secretMask
+		}
+		return masked
+	}
+	if v.IsZero() {
+		return v.Interface()
+	}
+	return secretMask
+}
@@ -59,6 +59,30 @@ func (m *Map) Get(key string) string {
 	return m.hashMap[m.keys[index%len(m.keys)]]
 }
 
+//按环上顺序返回最多 n 个不同的节点 第一个与 Get 相同
+func (m *Map) GetN(key string, n int) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	index := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool)
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(index+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
 func (m *Map) Delete(key string) error {
 	if len(key) == 0 {
 		return fmt.Errorf("deleted hash key is required")
@@ -70,11 +94,17 @@ func (m *Map) Delete(key string) error {
 		if v == key {
 			delete(m.hashMap, k)
 			index := sort.Search(len(m.keys), func(i int) bool {
-				return m.keys[i] == k
+				return m.keys[i] >= k
 			})
 			m.keys = append(m.keys[:index], m.keys[index+1:]...)
 		}
 	}
-	sort.Ints(m.keys)
 	return nil
 }
+
+//环上虚拟节点数
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.keys)
+}
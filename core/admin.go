@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+var errCircuitOpen = errors.New("circuit open")
+
+//节点返回结果
+type nodeReply struct {
+	body []byte
+	err  error
+}
+
+//并发向所有节点发送命令
+func (m *service) broadcast(ctx context.Context, q url.Values) map[string]nodeReply {
+	names := m.mserver.nodeNames()
+	replies := make(map[string]nodeReply, len(names))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range names {
+		getter, ok := m.mserver.getNodeByName(name)
+		if !ok {
+			continue
+		}
+		if !getter.breaker.allow() {
+			replies[name] = nodeReply{err: errCircuitOpen}
+			continue
+		}
+		wg.Add(1)
+		go func(name string, getter *NodeGetter) {
+			defer wg.Done()
+			body, err := getter.do(ctx, q)
+			mu.Lock()
+			replies[name] = nodeReply{body: body, err: err}
+			mu.Unlock()
+		}(name, getter)
+	}
+	wg.Wait()
+	return replies
+}
+
+//集群统计结果
+type clusterStats struct {
+	Total  Stats             `json:"total"`
+	Nodes  map[string]Stats  `json:"nodes"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+//节点 stats 命令
+func (h *NodeServer) serveStats(w http.ResponseWriter) {
+	writeJSON(w, h.mainCache.Stats())
+}
+
+//汇总所有节点的统计信息
+func (m *service) serveStats(w http.ResponseWriter, r *http.Request) {
+	res := clusterStats{Nodes: make(map[string]Stats)}
+	for name, reply := range m.broadcast(r.Context(), url.Values{"action": {"stats"}}) {
+		var s Stats
+		err := reply.err
+		if err == nil {
+			if json.Unmarshal(reply.body, &s) != nil {
+				err = errors.New(string(reply.body))
+			}
+		}
+		if err != nil {
+			if res.Errors == nil {
+				res.Errors = make(map[string]string)
+			}
+			res.Errors[name] = err.Error()
+			continue
+		}
+		res.Nodes[name] = s
+		res.Total.add(s)
+	}
+	writeJSON(w, res)
+}
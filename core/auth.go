@@ -0,0 +1,313 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//认证配置 配置了 token 或 HMAC 密钥后启用
+//分组为 key 中第一个 : 之前的部分 如 user:1 属于 user 分组 限定分组的凭证只能访问这些分组的 key
+//metrics 接口不做认证
+type AuthConfig struct {
+	Tokens        []string      `json:"tokens" secret:"true" usage:"bearer token 列表 格式 token:read|write|admin[:分组1|分组2]"`
+	HMACKeys      []string      `json:"hmac_keys" secret:"true" usage:"HMAC 签名密钥列表 格式 id:secret:read|write|admin[:分组1|分组2]"`
+	InternalToken string        `json:"internal_token" secret:"true" usage:"master 与节点之间互相访问使用的 token 拥有 admin 权限"`
+	MaxClockSkew  time.Duration `json:"max_clock_skew" usage:"HMAC 签名时间戳允许的偏差 期间 nonce 不能重复使用"`
+}
+
+var DefaultAuthConfig = AuthConfig{
+	MaxClockSkew: 5 * time.Minute,
+}
+
+func (c AuthConfig) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.HMACKeys) > 0 || c.InternalToken != ""
+}
+
+const (
+	authKeyIDHeader     = "X-Mycache-Key-Id"
+	authTimestampHeader = "X-Mycache-Timestamp"
+	authSignatureHeader = "X-Mycache-Signature"
+	authNonceHeader     = "X-Mycache-Nonce"
+)
+
+const maxNonceLen = 64
+
+//nonce 数量的清理阈值下限
+const minNonceSweep = 1024
+
+type permission int
+
+const (
+	permNone permission = iota
+	permRead
+	permWrite
+	permAdmin
+)
+
+var permissionNames = map[string]permission{"read": permRead, "write": permWrite, "admin": permAdmin}
+
+//各命令需要的权限 未列出的命令需要 write
+var actionPermissions = map[string]permission{
+	"ping": permRead, "get": permRead, "gets": permRead, "scan": permRead,
+	"hget": permRead, "hgetall": permRead, "lrange": permRead, "smembers": permRead, "sismember": permRead,
+	"nodes": permRead,
+	"stats": permAdmin, "deltag": permAdmin, "delprefix": permAdmin, "register": permAdmin, "ratelimit": permAdmin,
+}
+
+type credential struct {
+	id     string //限流使用的客户端标识
+	secret string
+	perm   permission
+	groups map[string]bool //为空表示不限分组
+}
+
+//凭证是否允许访问 key 所属的分组
+func (c *credential) allowKey(key string) bool {
+	if len(c.groups) == 0 {
+		return true
+	}
+	i := strings.Index(key, ":")
+	return i > 0 && c.groups[key[:i]]
+}
+
+//token 的 sha256 与对应凭证
+type tokenEntry struct {
+	sum  [sha256.Size]byte
+	cred *credential
+}
+
+type authenticator struct {
+	tokens  []tokenEntry
+	keys    map[string]*credential
+	maxSkew time.Duration
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time //时间戳有效期内已使用的 nonce 及过期时间
+	nextSweep int                  //nonces 达到该数量时清理过期的 nonce
+}
+
+//未启用认证时返回 nil
+func newAuthenticator(c AuthConfig) (*authenticator, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	a := &authenticator{
+		keys:      make(map[string]*credential),
+		maxSkew:   c.MaxClockSkew,
+		nonces:    make(map[string]time.Time),
+		nextSweep: minNonceSweep,
+	}
+	for _, s := range c.Tokens {
+		parts := strings.Split(s, ":")
+		cred, err := parseCredential(parts[0], parts[1:])
+		if err != nil {
+			return nil, fmt.Errorf("auth.tokens: %v", err)
+		}
+		cred.id = "token:" + shortHash(parts[0])
+		a.addToken(parts[0], cred)
+	}
+	for _, s := range c.HMACKeys {
+		parts := strings.Split(s, ":")
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, fmt.Errorf("auth.hmac_keys: expected id:secret:permission")
+		}
+		cred, err := parseCredential(parts[1], parts[2:])
+		if err != nil {
+			return nil, fmt.Errorf("auth.hmac_keys %s: %v", parts[0], err)
+		}
+		cred.id = "key:" + parts[0]
+		a.keys[parts[0]] = cred
+	}
+	if c.InternalToken != "" {
+		a.addToken(c.InternalToken, &credential{id: "internal", secret: c.InternalToken, perm: permAdmin})
+	}
+	return a, nil
+}
+
+func (a *authenticator) addToken(token string, cred *credential) {
+	a.tokens = append(a.tokens, tokenEntry{sum: sha256.Sum256([]byte(token)), cred: cred})
+}
+
+//比较 token 的 sha256 耗时与 token 内容无关 同一个 token 配置多次时后配置的生效
+func (a *authenticator) lookupToken(token string) (*credential, bool) {
+	sum := sha256.Sum256([]byte(token))
+	var found *credential
+	for _, e := range a.tokens {
+		if subtle.ConstantTimeCompare(sum[:], e.sum[:]) == 1 {
+			found = e.cred
+		}
+	}
+	return found, found != nil
+}
+
+//rest 为 权限[:分组1|分组2]
+func parseCredential(secret string, rest []string) (*credential, error) {
+	if secret == "" || len(rest) == 0 || len(rest) > 2 {
+		return nil, fmt.Errorf("expected secret:permission[:groups]")
+	}
+	perm, ok := permissionNames[rest[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown permission %q", rest[0])
+	}
+	cred := &credential{secret: secret, perm: perm}
+	if len(rest) == 2 {
+		cred.groups = make(map[string]bool)
+		for _, g := range strings.Split(rest[1], "|") {
+			if g != "" {
+				cred.groups[g] = true
+			}
+		}
+	}
+	return cred, nil
+}
+
+//bearer token 或 HMAC 签名
+func (a *authenticator) authenticate(r *http.Request) (*credential, error) {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		if cred, ok := a.lookupToken(strings.TrimPrefix(h, "Bearer ")); ok {
+			return cred, nil
+		}
+		return nil, fmt.Errorf("invalid token")
+	}
+	id := r.Header.Get(authKeyIDHeader)
+	if id == "" {
+		return nil, fmt.Errorf("credentials required")
+	}
+	cred, ok := a.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id")
+	}
+	ts := r.Header.Get(authTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return nil, fmt.Errorf("timestamp out of range")
+	}
+	nonce := r.Header.Get(authNonceHeader)
+	if nonce == "" || len(nonce) > maxNonceLen {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	sig, err := hex.DecodeString(r.Header.Get(authSignatureHeader))
+	if err != nil || !hmac.Equal(sig, signature(cred.secret, r.Method, r.URL.Path, r.URL.RawQuery, ts, nonce)) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	//签名通过后才记录 nonce 伪造的请求不会占用空间
+	if !a.useNonce(id+":"+nonce, time.Unix(sec, 0).Add(a.maxSkew)) {
+		return nil, fmt.Errorf("nonce already used")
+	}
+	return cred, nil
+}
+
+//记录 nonce 已使用过时返回 false expires 之后时间戳失效 nonce 可以清理
+func (a *authenticator) useNonce(nonce string, expires time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if exp, ok := a.nonces[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	if len(a.nonces) >= a.nextSweep {
+		for k, exp := range a.nonces {
+			if !now.Before(exp) {
+				delete(a.nonces, k)
+			}
+		}
+		a.nextSweep = 2 * len(a.nonces)
+		if a.nextSweep < minNonceSweep {
+			a.nextSweep = minNonceSweep
+		}
+	}
+	a.nonces[nonce] = expires
+	return true
+}
+
+//校验请求是否有执行 action 的权限 失败时写入 401/403
+//scope 为命令涉及的 key 或前缀 用于分组校验
+func (a *authenticator) allow(w http.ResponseWriter, r *http.Request, action string, scope string) bool {
+	_, ok := a.verify(w, r, action, scope)
+	return ok
+}
+
+//同 allow 并返回通过校验的凭证标识 未启用认证时为空
+func (a *authenticator) verify(w http.ResponseWriter, r *http.Request, action string, scope string) (string, bool) {
+	if a == nil {
+		return "", true
+	}
+	cred, err := a.authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	need, ok := actionPermissions[strings.ToLower(action)]
+	if !ok {
+		need = permWrite
+	}
+	if cred.perm < need || !cred.allowKey(scope) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return cred.id, true
+}
+
+//包装 handler 要求指定权限
+func (a *authenticator) wrap(h http.Handler, action string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.allow(w, r, action, "") {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+//请求涉及的 key 或前缀
+func authScope(r *http.Request) string {
+	values := r.URL.Query()
+	if key := values.Get("key"); key != "" {
+		return key
+	}
+	return values.Get("prefix")
+}
+
+func signature(secret, method, path, rawQuery, timestamp, nonce string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + rawQuery + "\n" + timestamp + "\n" + nonce))
+	return mac.Sum(nil)
+}
+
+//使用 HMAC 密钥为请求签名 供客户端使用 每次签名使用新的随机 nonce 签名后的请求只能发送一次
+func SignRequest(r *http.Request, keyID string, secret string) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(b)
+	r.Header.Set(authKeyIDHeader, keyID)
+	r.Header.Set(authTimestampHeader, ts)
+	r.Header.Set(authNonceHeader, nonce)
+	r.Header.Set(authSignatureHeader, hex.EncodeToString(signature(secret, r.Method, r.URL.Path, r.URL.RawQuery, ts, nonce)))
+	return nil
+}
+
+//为访问其他节点的请求带上内部 token
+type authTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+//熔断器配置
+type BreakerOptions struct {
+	FailureThreshold    int           `json:"failure_threshold" usage:"连续失败多少次后熔断 0 不启用"`
+	SlowThreshold       time.Duration `json:"slow_threshold" usage:"超过该耗时的请求视为失败 0 不判断 耗时包含节点未命中时调用 getter 的时间 默认不判断"`
+	OpenTimeout         time.Duration `json:"open_timeout" usage:"熔断后多久进入半开状态"`
+	HalfOpenMaxRequests int           `json:"half_open_max_requests" usage:"半开状态允许的探测请求数"`
+}
+
+var DefaultBreakerOptions = BreakerOptions{
+	FailureThreshold:    3,
+	OpenTimeout:         2 * time.Second,
+	HalfOpenMaxRequests: 1,
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+//节点熔断器 由真实请求的错误和耗时驱动
+type breaker struct {
+	mu       sync.Mutex
+	opts     BreakerOptions
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probes   int //半开状态下正在进行的探测请求
+}
+
+func newBreaker(o BreakerOptions) *breaker {
+	return &breaker{opts: o}
+}
+
+//是否允许请求通过
+func (b *breaker) allow() bool {
+	if b == nil || b.opts.FailureThreshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.opts.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probes = 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.opts.HalfOpenMaxRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+//记录请求结果
+func (b *breaker) record(err error, d time.Duration) {
+	if b == nil || b.opts.FailureThreshold <= 0 {
+		return
+	}
+	failed := err != nil || (b.opts.SlowThreshold > 0 && d > b.opts.SlowThreshold)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.trip()
+			return
+		}
+		b.probes--
+		b.state = breakerClosed
+		b.failures = 0
+	case breakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.trip()
+		}
+	}
+}
+
+//请求被调用方取消 不计入结果 释放半开状态的探测名额
+func (b *breaker) cancel() {
+	if b == nil || b.opts.FailureThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *breaker) trip() {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	b.failures = 0
+	b.probes = 0
+}
+
+func (b *breaker) currentState() breakerState {
+	if b == nil {
+		return breakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
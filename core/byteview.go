@@ -1,8 +1,13 @@
 package core
 
+import "encoding/binary"
+
 //上层数据结构
 type ByteView struct {
-	b []byte
+	b     []byte
+	codec uint8 //压缩算法 0 表示未压缩
+	//由 getter 加载的时间 UnixNano 0 表示由调用方写入 不会过期
+	loaded int64
 }
 
 func (v ByteView) Len() int {
@@ -17,6 +22,23 @@ func (v ByteView) String() string {
 	return string(v.b)
 }
 
+//序列化头: codec 1 | loaded 8
+const viewHeaderSize = 9
+
+//序列化 用于 arena 与磁盘层等只能保存 []byte 的存储
+func (v ByteView) marshal() []byte {
+	b := make([]byte, viewHeaderSize+len(v.b))
+	b[0] = v.codec
+	binary.LittleEndian.PutUint64(b[1:], uint64(v.loaded))
+	copy(b[viewHeaderSize:], v.b)
+	return b
+}
+
+//反序列化 返回的数据引用 b
+func unmarshalView(b []byte) ByteView {
+	return ByteView{b: b[viewHeaderSize:], codec: b[0], loaded: int64(binary.LittleEndian.Uint64(b[1:]))}
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
@@ -1,41 +1,255 @@
 package core
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/ylt94/mycache/disk"
 	"github.com/ylt94/mycache/lru"
 )
 
 type cache struct {
 	mu         sync.RWMutex
-	lru        *lru.Cache
+	lru        store //底层存储 默认为 lru.Cache
 	cacheBytes int64
+	engine     string
+	tagKeys    map[string]map[string]struct{} //tag -> keys
+	keyTags    map[string][]string            //key -> tags
+	disk       *disk.Store                    //可选的磁盘层 为空时淘汰的数据直接丢弃
+	diskCount  diskCounters
 }
 
-func (c *cache) add(key string, value ByteView) {
+//需持有写锁
+func (c *cache) lazyInit() {
+	if c.lru == nil {
+		var onEvicted func(key string, value lru.Value, version uint64)
+		if c.disk != nil {
+			onEvicted = c.onEvicted
+		}
+		c.lru = newStore(c.engine, c.cacheBytes, c.onRemoved, onEvicted)
+		c.tagKeys = make(map[string]map[string]struct{})
+		c.keyTags = make(map[string][]string)
+	}
+}
+
+//数据删除或淘汰时清理 tag 索引
+func (c *cache) onRemoved(key string, value lru.Value) {
+	c.untag(key)
+}
+
+func (c *cache) untag(key string) {
+	for _, tag := range c.keyTags[key] {
+		keys := c.tagKeys[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.tagKeys, tag)
+		}
+	}
+	delete(c.keyTags, key)
+}
+
+//存储引擎能否保存 value 不能时不修改原有数据 需持有写锁
+func (c *cache) check(key string, value lru.Value) error {
+	if s, ok := c.lru.(*arenaStore); ok {
+		return s.check(key, value)
+	}
+	return nil
+}
+
+//写入数据 tags 会替换 key 原有的 tag
+//其他替换值的写入(cas/setnx/setxx/incr 及新建 hash/list/set)都会清除原有的 tag
+func (c *cache) add(key string, value ByteView, tags ...string) error {
+	defer nodeMemory.reclaim()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	c.lazyInit()
+	if err := c.check(key, value); err != nil {
+		return err
+	}
+	c.untag(key)
+	if c.disk != nil {
+		c.disk.Del(key)
 	}
 	c.lru.Add(key, value)
+	//写入后可能立刻被淘汰
+	if _, ok := c.lru.Peek(key); !ok || len(tags) == 0 {
+		return nil
+	}
+	for _, tag := range tags {
+		if c.tagKeys[tag] == nil {
+			c.tagKeys[tag] = make(map[string]struct{})
+		}
+		c.tagKeys[tag][key] = struct{}{}
+	}
+	c.keyTags[key] = tags
+	return nil
+}
+
+//删除 tag 下的所有 key 返回删除条数
+func (c *cache) delByTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lru == nil {
+		return 0
+	}
+	keys := make([]string, 0, len(c.tagKeys[tag]))
+	for key := range c.tagKeys[tag] {
+		keys = append(keys, key)
+	}
+	n := 0
+	for _, key := range keys {
+		if _, err := c.lru.Del(key); err == nil || c.delDisk(key) {
+			n++
+		}
+	}
+	return n
+}
+
+//删除指定前缀的所有 key 返回删除条数
+func (c *cache) delByPrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lru == nil {
+		return 0
+	}
+	n := 0
+	for _, key := range c.keys() {
+		if strings.HasPrefix(key, prefix) {
+			if _, err := c.lru.Del(key); err == nil || c.delDisk(key) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (c *cache) getWithVersion(key string) (value ByteView, version uint64, ok bool) {
+	defer nodeMemory.reclaim()
+	c.lockPromoted(key)
+	defer c.mu.Unlock()
+
+	if c.lru == nil {
+		return
+	}
+
+	if v, ver, ok := c.lru.GetWithVersion(key); ok {
+		b, ok := v.(ByteView)
+		return b, ver, ok
+	}
+	return
+}
+
+//条件写入 mode: cas/nx/xx
+func (c *cache) addIf(mode string, key string, value ByteView, version uint64) (uint64, bool, error) {
+	defer nodeMemory.reclaim()
+	c.lockPromoted(key)
+	defer c.mu.Unlock()
+
+	c.lazyInit()
+	if err := c.check(key, value); err != nil {
+		return 0, false, err
+	}
+
+	var ver uint64
+	var ok bool
+	switch mode {
+	case "nx":
+		ver, ok = c.lru.AddIfAbsent(key, value)
+	case "xx":
+		ver, ok = c.lru.AddIfPresent(key, value)
+	default:
+		ver, ok = c.lru.AddIfVersion(key, value, version)
+	}
+	if ok {
+		c.untag(key)
+	}
+	return ver, ok, nil
+}
+
+func (c *cache) delIfVersion(key string, version uint64) (bool, error) {
+	c.lockPromoted(key)
+	defer c.mu.Unlock()
+
+	if c.lru == nil {
+		return false, fmt.Errorf("data not exists")
+	}
+	return c.lru.DelIfVersion(key, version)
 }
 
+//lru.Get 会调整淘汰顺序 需要写锁
 func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	defer nodeMemory.reclaim()
+	c.lockPromoted(key)
+	defer c.mu.Unlock()
 
 	if c.lru == nil {
 		return
 	}
 
 	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+		b, ok := v.(ByteView)
+		return b, ok
 	}
 	return
 }
 
+//获取任意类型的值
+func (c *cache) lookup(key string) (value lru.Value, ok bool) {
+	defer nodeMemory.reclaim()
+	c.lockPromoted(key)
+	defer c.mu.Unlock()
+
+	if c.lru == nil {
+		return
+	}
+	return c.lru.Get(key)
+}
+
+//在写锁内修改 key 对应的值 不存在时使用 create 创建
+//fn 返回 changed 为 false 时不写回 remove 为 true 时删除该 key
+func (c *cache) mutate(key string, create func() lru.Value, fn func(v lru.Value) (changed bool, remove bool, err error)) error {
+	defer nodeMemory.reclaim()
+	c.lockPromoted(key)
+	defer c.mu.Unlock()
+
+	c.lazyInit()
+	if _, ok := c.lru.(*arenaStore); ok && create != nil {
+		return ErrUnsupportedType
+	}
+
+	v, ok := c.lru.Get(key)
+	if !ok {
+		if create == nil {
+			return nil
+		}
+		v = create()
+	}
+	changed, remove, err := fn(v)
+	if err != nil || !changed {
+		return err
+	}
+	if remove {
+		if ok {
+			c.lru.Del(key)
+		}
+		return nil
+	}
+	if err := c.check(key, v); err != nil {
+		return err
+	}
+	if !ok {
+		c.untag(key)
+	}
+	c.lru.Add(key, v)
+	return nil
+}
+
 func (c *cache) del(key string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -44,8 +258,88 @@ func (c *cache) del(key string) error {
 		return nil
 	}
 
-	if _, err := c.lru.Del(key); err != nil {
+	if _, err := c.lru.Del(key); err != nil && !c.delDisk(key) {
 		return err
 	}
 	return nil
 }
+
+//原子自增 在写锁内完成读取-计算-写回
+func (c *cache) incr(key string, delta int64) (int64, error) {
+	defer nodeMemory.reclaim()
+	c.lockPromoted(key)
+	defer c.mu.Unlock()
+
+	c.lazyInit()
+
+	var n int64
+	if v, ok := c.lru.Get(key); ok {
+		b, ok := v.(ByteView)
+		if !ok {
+			return 0, ErrWrongType
+		}
+		i, err := strconv.ParseInt(b.String(), 10, 64)
+		if err != nil || b.codec != 0 {
+			return 0, fmt.Errorf("value is not an integer")
+		}
+		n = i
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("increment or decrement would overflow")
+	}
+	n += delta
+	v := ByteView{b: []byte(strconv.FormatInt(n, 10))}
+	if err := c.check(key, v); err != nil {
+		return 0, err
+	}
+	c.untag(key)
+	c.lru.Add(key, v)
+	return n, nil
+}
+
+//内存与磁盘中的所有 key 需持有锁
+func (c *cache) keys() []string {
+	keys := c.lru.Keys()
+	if c.disk != nil {
+		keys = append(keys, c.disk.Keys()...)
+	}
+	return keys
+}
+
+//已用内存
+func (c *cache) usedBytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.UsedBytes()
+}
+
+//淘汰最久未使用的数据直到释放 n 字节 返回实际释放的字节数
+func (c *cache) evict(n int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var freed int64
+	for c.lru != nil && freed < n {
+		f, ok := c.lru.RemoveOldest()
+		if !ok {
+			break
+		}
+		freed += f
+	}
+	return freed
+}
+
+//底层存储统计
+func (c *cache) stats() lru.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.lru == nil {
+		return lru.Stats{MaxBytes: c.cacheBytes}
+	}
+	return c.lru.Stats()
+}
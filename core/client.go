@@ -0,0 +1,171 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//访问节点的 http 客户端配置
+type ClientOptions struct {
+	MaxIdleConns        int           `json:"max_idle_conns" usage:"连接池最大空闲连接"`
+	MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host" usage:"每个节点最大空闲连接"`
+	MaxConnsPerHost     int           `json:"max_conns_per_host" usage:"每个节点最大连接 0 不限制"`
+	IdleConnTimeout     time.Duration `json:"idle_conn_timeout" usage:"空闲连接超时"`
+	DialTimeout         time.Duration `json:"dial_timeout" usage:"建立连接超时"`
+	RequestTimeout      time.Duration `json:"request_timeout" usage:"单次请求超时 0 不限制"`
+	MaxRetries          int           `json:"max_retries" usage:"幂等读请求的最大重试次数"`
+	RetryBackoff        time.Duration `json:"retry_backoff" usage:"首次重试等待 之后指数增长"`
+	MaxRetryBackoff     time.Duration `json:"max_retry_backoff" usage:"重试等待上限"`
+}
+
+var DefaultClientOptions = ClientOptions{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     90 * time.Second,
+	DialTimeout:         time.Second,
+	RequestTimeout:      3 * time.Second,
+	MaxRetries:          2,
+	RetryBackoff:        20 * time.Millisecond,
+	MaxRetryBackoff:     time.Second,
+}
+
+//访问其他节点的客户端 启用认证时带上内部 token 启动时创建一次 所有节点共用连接池
+func newHTTPClient(cfg Config) (*http.Client, error) {
+	tlsConfig, err := clientTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	o := cfg.Client
+	dialer := &net.Dialer{Timeout: o.DialTimeout, KeepAlive: 30 * time.Second}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        o.MaxIdleConns,
+		MaxIdleConnsPerHost: o.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     o.MaxConnsPerHost,
+		IdleConnTimeout:     o.IdleConnTimeout,
+		TLSClientConfig:     tlsConfig,
+	}
+	if cfg.Auth.InternalToken != "" {
+		transport = &authTransport{base: transport, token: cfg.Auth.InternalToken}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func newNodeGetter(baseURL string, cfg Config, client *http.Client) *NodeGetter {
+	return &NodeGetter{
+		baseURL:  baseURL,
+		basePath: cfg.BasePath,
+		opts:     cfg.Client,
+		client:   client,
+		breaker:  newBreaker(cfg.Breaker),
+	}
+}
+
+//幂等的读命令 失败时可以重试
+var idempotentActions = map[string]bool{
+	"ping": true, "get": true, "gets": true, "scan": true, "stats": true,
+	"hget": true, "hgetall": true, "lrange": true, "smembers": true, "sismember": true,
+}
+
+//节点响应
+type nodeResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+//发送请求 读请求在网络错误或 5xx 时按指数退避重试 ctx 取消时立即返回
+func (g *NodeGetter) fetch(ctx context.Context, u string, header http.Header, action string) (res *nodeResponse, err error) {
+	start := time.Now()
+	defer func() {
+		//调用方主动取消不计入熔断
+		if ctx.Err() == context.Canceled {
+			g.breaker.cancel()
+			return
+		}
+		//4xx 说明节点正常 不计入熔断
+		if res != nil {
+			g.breaker.record(nil, time.Since(start))
+			return
+		}
+		g.breaker.record(err, time.Since(start))
+	}()
+
+	retries := 0
+	if idempotentActions[strings.ToLower(action)] {
+		retries = g.opts.MaxRetries
+	}
+
+	backoff := g.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		res, retryable, err = g.fetchOnce(ctx, u, header)
+		if err == nil || !retryable || attempt >= retries {
+			return res, err
+		}
+		res = nil
+
+		wait := backoff
+		if wait > 0 {
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if g.opts.MaxRetryBackoff > 0 && backoff > g.opts.MaxRetryBackoff {
+			backoff = g.opts.MaxRetryBackoff
+		}
+	}
+}
+
+func (g *NodeGetter) fetchOnce(ctx context.Context, u string, header http.Header) (*nodeResponse, bool, error) {
+	if g.opts.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opts.RequestTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	client := g.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		//调用方取消时不再重试
+		return nil, ctx.Err() == nil || ctx.Err() == context.DeadlineExceeded, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return nil, true, fmt.Errorf("server returned:%v", res.Status)
+	}
+
+	bytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("reading response  body: %v", err)
+	}
+	out := &nodeResponse{status: res.StatusCode, header: res.Header, body: bytes}
+	//4xx 同时返回响应与错误 由调用方决定是否转发给客户端
+	if res.StatusCode != http.StatusOK {
+		return out, false, fmt.Errorf("server returned:%v", res.Status)
+	}
+	return out, false, nil
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ylt94/mycache/config"
+	"github.com/ylt94/mycache/logger"
+)
+
+//集群配置文件
+type clusterFile struct {
+	Nodes []string `json:"nodes"`
+}
+
+//读取集群节点列表 支持 json 与 yaml 格式
+func LoadClusterFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cf clusterFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		cf.Nodes, err = config.ParseYAMLList(data, "nodes")
+	default:
+		err = json.Unmarshal(data, &cf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse cluster file %s: %v", path, err)
+	}
+	return normalizeNodes(cf.Nodes), nil
+}
+
+//逗号分隔的节点列表
+func ParseNodeList(s string) []string {
+	return normalizeNodes(strings.Split(s, ","))
+}
+
+func normalizeNodes(nodes []string) []string {
+	seen := make(map[string]bool, len(nodes))
+	res := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		n = strings.TrimRight(strings.TrimSpace(n), "/")
+		if n != "" && !seen[n] {
+			seen[n] = true
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+//监听集群配置文件 收到 SIGHUP 或文件修改时间变化时重新加载 返回停止函数
+func WatchClusterFile(path string, interval time.Duration, apply func(nodes []string)) func() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stop := make(chan struct{})
+
+	var modTime time.Time
+	var size int64
+	load := func(reason string) {
+		nodes, err := LoadClusterFile(path)
+		if err != nil {
+			logger.Error("reload cluster file failed", logger.F("path", path), logger.F("err", err))
+			return
+		}
+		logger.Info("cluster file loaded", logger.F("path", path), logger.F("reason", reason), logger.F("nodes", nodes))
+		apply(nodes)
+	}
+	if fi, err := os.Stat(path); err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+	load("start")
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+				load("sighup")
+			case <-ticker.C:
+				fi, err := os.Stat(path)
+				if err == nil && (!fi.ModTime().Equal(modTime) || fi.Size() != size) {
+					modTime, size = fi.ModTime(), fi.Size()
+					load("modified")
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+//按静态节点列表调整 master 的节点 只移除之前由静态配置加入的节点
+func (m *master) SetStaticNodes(nodes []string) {
+	want := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		want[n] = true
+	}
+
+	m.mu.Lock()
+	old := m.staticNodes
+	m.staticNodes = want
+	m.mu.Unlock()
+
+	for n := range old {
+		if !want[n] {
+			logger.Info("static node removed", logger.F("node", n))
+			m.removeNode(n)
+		}
+	}
+	for n := range want {
+		if _, ok := m.getNodeByName(n); ok {
+			continue
+		}
+		if err := m.addNode(n); err == nil {
+			logger.Info("static node added", logger.F("node", n))
+		}
+	}
+}
+
+//静态节点判定为 dead 后继续探测 心跳恢复时重新加入
+func (m *master) watchStatic(name string) {
+	probe := newNodeGetter(name, m.cfg, m.client)
+	ticker := time.NewTicker(m.cfg.HeartBeat.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.RLock()
+		static := m.staticNodes[name]
+		_, exists := m.nodeGetters[name]
+		m.mu.RUnlock()
+		//已从静态配置中删除或已重新加入
+		if !static || exists {
+			return
+		}
+		if probe.heartBeat(m.cfg.HeartBeat.Timeout) != nil {
+			continue
+		}
+		if err := m.addNode(name); err == nil {
+			logger.Info("static node recovered", logger.F("node", name))
+		}
+		return
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+)
+
+//value 压缩算法
+type Codec interface {
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+const codecNone = "none"
+
+//编码写入 ByteView 及磁盘数据 与算法名称一一对应 不能修改
+//snappy/zstd 不在标准库中 不内置实现 编码已预留 通过 RegisterCodec(CodecSnappy, "snappy", c) 接入
+const (
+	CodecNone    uint8 = 0
+	CodecGzip    uint8 = 1
+	CodecDeflate uint8 = 2
+	CodecSnappy  uint8 = 3
+	CodecZstd    uint8 = 4
+)
+
+//预留编码对应的名称 注册时校验
+var reservedCodecs = map[uint8]string{
+	CodecNone:    codecNone,
+	CodecGzip:    "gzip",
+	CodecDeflate: "deflate",
+	CodecSnappy:  "snappy",
+	CodecZstd:    "zstd",
+}
+
+var (
+	codecMu  sync.RWMutex
+	codecIDs = map[string]uint8{codecNone: CodecNone}
+	codecs   [256]Codec
+)
+
+//注册压缩算法 id 为写入数据的编码 同一个名称在所有节点及每次启动时必须使用相同的 id
+//预留的 id 只能注册对应名称的算法 再次注册同一个 id 和名称时替换实现
+func RegisterCodec(id uint8, name string, c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	if id == CodecNone || name == codecNone {
+		panic("codec id 0 and name none are reserved")
+	}
+	if n, ok := reservedCodecs[id]; ok && n != name {
+		panic(fmt.Sprintf("codec id %d is reserved for %s", id, n))
+	}
+	if cur, ok := codecIDs[name]; ok && cur != id {
+		panic(fmt.Sprintf("codec %s already registered with id %d", name, cur))
+	}
+	for n, cur := range codecIDs {
+		if cur == id && n != name {
+			panic(fmt.Sprintf("codec id %d already registered by %s", id, n))
+		}
+	}
+	codecIDs[name] = id
+	codecs[id] = c
+}
+
+func init() {
+	RegisterCodec(CodecGzip, "gzip", gzipCodec{})
+	RegisterCodec(CodecDeflate, "deflate", deflateCodec{})
+}
+
+//按名称查找 返回编码
+func codecByName(name string) (uint8, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	id, ok := codecIDs[name]
+	return id, ok
+}
+
+func codecByID(id uint8) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	c := codecs[id]
+	return c, c != nil
+}
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+var flateWriters = sync.Pool{New: func() interface{} {
+	w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return w
+}}
+
+type deflateCodec struct{}
+
+func (deflateCodec) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCodec) Decompress(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+//压缩统计
+type compressCounters struct {
+	compressed int64 //压缩后写入的条数
+	rawBytes   int64 //压缩前的字节数
+	outBytes   int64 //压缩后的字节数
+}
+
+//写入前按配置压缩 压缩后没有变小时保存原始数据
+func (g *mcache) encode(b []byte) ByteView {
+	if g.codec == 0 || len(b) < g.compressMinSize {
+		return ByteView{b: b}
+	}
+	c, ok := codecByID(g.codec)
+	if !ok {
+		return ByteView{b: b}
+	}
+	out, err := c.Compress(b)
+	if err != nil || len(out) >= len(b) {
+		return ByteView{b: b}
+	}
+	atomic.AddInt64(&g.compress.compressed, 1)
+	atomic.AddInt64(&g.compress.rawBytes, int64(len(b)))
+	atomic.AddInt64(&g.compress.outBytes, int64(len(out)))
+	return ByteView{b: out, codec: g.codec}
+}
+
+//读取时解压 返回的数据可以由调用方修改
+func decode(v ByteView) ([]byte, error) {
+	if v.codec == 0 {
+		return v.ByteSlice(), nil
+	}
+	c, ok := codecByID(v.codec)
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %d", v.codec)
+	}
+	return c.Decompress(v.b)
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := map[string][]byte{
+		"empty":      {},
+		"small":      []byte("a"),
+		"repetitive": bytes.Repeat([]byte("mycache "), 1000),
+		"random":     random,
+	}
+	for _, name := range []string{"gzip", "deflate"} {
+		id, ok := codecByName(name)
+		if !ok {
+			t.Fatalf("codec %s not registered", name)
+		}
+		c, _ := codecByID(id)
+		for in, b := range inputs {
+			out, err := c.Compress(b)
+			if err != nil {
+				t.Fatalf("%s %s: Compress: %v", name, in, err)
+			}
+			got, err := c.Decompress(out)
+			if err != nil || !bytes.Equal(got, b) {
+				t.Fatalf("%s %s: round trip = %d bytes, %v; want %d bytes", name, in, len(got), err, len(b))
+			}
+		}
+	}
+}
+
+//压缩对调用方透明 小于阈值或压缩后没有变小时保存原始数据
+func TestCacheCompression(t *testing.T) {
+	random := make([]byte, 2048)
+	rand.New(rand.NewSource(1)).Read(random)
+	for _, name := range []string{"gzip", "deflate"} {
+		t.Run(name, func(t *testing.T) {
+			g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, Compression: name, CompressMinSize: 64}, nil)
+			defer g.Close()
+			id, _ := codecByName(name)
+			values := []struct {
+				key, value string
+				codec      uint8
+			}{
+				{"big", strings.Repeat("value ", 500), id},
+				{"small", "value", CodecNone},
+				{"random", string(random), CodecNone},
+			}
+			for _, v := range values {
+				g.Set(v.key, v.value)
+				b, err := g.Get(v.key)
+				if err != nil || string(b) != v.value {
+					t.Fatalf("Get(%s) = %d bytes, %v; want %d bytes", v.key, len(b), err, len(v.value))
+				}
+				stored, _ := g.baseCache.lookup(v.key)
+				if c := stored.(ByteView).codec; c != v.codec {
+					t.Fatalf("%s stored with codec %d; want %d", v.key, c, v.codec)
+				}
+			}
+			if s := g.Stats(); s.Compressed != 1 {
+				t.Fatalf("Compressed = %d; want 1", s.Compressed)
+			}
+		})
+	}
+}
+
+//编码固定 与注册顺序无关
+func TestCodecIDs(t *testing.T) {
+	cases := []struct {
+		name string
+		id   uint8
+		ok   bool
+	}{
+		{codecNone, CodecNone, true},
+		{"gzip", 1, true},
+		{"deflate", 2, true},
+		//预留但未注册
+		{"snappy", 0, false},
+		{"zstd", 0, false},
+		{"lz4", 0, false},
+	}
+	for _, c := range cases {
+		id, ok := codecByName(c.name)
+		if ok != c.ok || ok && id != c.id {
+			t.Errorf("codecByName(%s) = %d, %v; want %d, %v", c.name, id, ok, c.id, c.ok)
+		}
+	}
+	if CodecSnappy != 3 || CodecZstd != 4 {
+		t.Fatalf("snappy/zstd ids = %d/%d; want 3/4", CodecSnappy, CodecZstd)
+	}
+	if reservedCodecs[CodecSnappy] != "snappy" || reservedCodecs[CodecZstd] != "zstd" {
+		t.Fatal("snappy/zstd ids not reserved")
+	}
+}
+
+type xorCodec struct{}
+
+func (xorCodec) Compress(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0xff
+	}
+	return out, nil
+}
+
+func (xorCodec) Decompress(b []byte) ([]byte, error) {
+	return xorCodec{}.Compress(b)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	RegisterCodec(200, "test-xor", xorCodec{})
+	if id, ok := codecByName("test-xor"); !ok || id != 200 {
+		t.Fatalf("codecByName(test-xor) = %d, %v; want 200, true", id, ok)
+	}
+	//相同的 id 和名称再次注册时替换实现
+	RegisterCodec(200, "test-xor", xorCodec{})
+	RegisterCodec(CodecGzip, "gzip", gzipCodec{})
+
+	panics := map[string]func(){
+		"id 0":                  func() { RegisterCodec(CodecNone, "other", xorCodec{}) },
+		"name none":             func() { RegisterCodec(201, codecNone, xorCodec{}) },
+		"reserved id":           func() { RegisterCodec(CodecSnappy, "not-snappy", xorCodec{}) },
+		"reserved builtin id":   func() { RegisterCodec(CodecGzip, "test-gzip", xorCodec{}) },
+		"name with another id":  func() { RegisterCodec(201, "test-xor", xorCodec{}) },
+		"id with another name":  func() { RegisterCodec(200, "test-xor2", xorCodec{}) },
+		"builtin with other id": func() { RegisterCodec(202, "deflate", xorCodec{}) },
+	}
+	for name, fn := range panics {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: RegisterCodec did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+	if _, ok := codecByID(201); ok {
+		t.Fatal("failed registration left a codec behind")
+	}
+}
+
+//未注册的编码解码时返回错误 不会当作原始数据返回
+func TestDecodeUnknownCodec(t *testing.T) {
+	for _, id := range []uint8{CodecSnappy, CodecZstd, 250} {
+		if b, err := decode(ByteView{b: []byte("data"), codec: id}); err == nil {
+			t.Errorf("decode with codec %d = %q; want error", id, b)
+		}
+	}
+	//经过磁盘层序列化后编码保留
+	v := unmarshalView(ByteView{b: []byte("data"), codec: CodecSnappy}.marshal())
+	if v.codec != CodecSnappy {
+		t.Fatalf("codec after marshal = %d; want %d", v.codec, CodecSnappy)
+	}
+	if _, err := decode(v); err == nil {
+		t.Fatal("decode after marshal: want error")
+	}
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//缓存配置
+type CacheConfig struct {
+	MaxBytes int64  `json:"max_bytes" flag:"cacheBytes" usage:"单个节点缓存的最大字节数 0 不限制"`
+	Engine   string `json:"engine" usage:"存储引擎 lru/arena arena 将数据存放在大块字节数组中以减少 GC 扫描 只支持字符串类型 需要设置 max_bytes"`
+	//压缩对调用方透明 已用内存按压缩后的大小计算
+	Compression     string `json:"compression" usage:"value 压缩算法 none/gzip/deflate 或通过 RegisterCodec 注册的算法"`
+	CompressMinSize int    `json:"compress_min_size" usage:"value 达到该字节数才压缩"`
+	//磁盘层 内存淘汰的数据写入本地文件 数据只在进程运行期间有效
+	DiskDir      string `json:"disk_dir" usage:"磁盘层数据目录 为空不启用 每个缓存需使用单独的目录 启动时清空"`
+	DiskMaxBytes int64  `json:"disk_max_bytes" usage:"磁盘层的最大字节数"`
+	//负缓存 getter 返回错误后在有效期内直接返回该错误 不再调用 getter
+	NegativeTTL        time.Duration `json:"negative_ttl" usage:"getter 返回 ErrNotFound 后缓存不存在标记的时长 0 不启用"`
+	ErrorTTL           time.Duration `json:"error_ttl" usage:"getter 返回其他错误后缓存该错误的时长 0 不缓存错误"`
+	NegativeMaxEntries int           `json:"negative_max_entries" usage:"负缓存的最大条数 0 不限制"`
+	//只对 getter 加载的数据生效 调用方写入的数据不会过期
+	SoftTTL      time.Duration `json:"soft_ttl" usage:"getter 加载的数据超过该时长后 Get 直接返回旧数据并在后台刷新 0 不启用"`
+	HardTTL      time.Duration `json:"hard_ttl" usage:"getter 加载的数据超过该时长后 Get 等待重新加载 0 不启用"`
+	StaleOnError bool          `json:"stale_on_error" usage:"重新加载失败时返回旧数据"`
+	LoadTimeout  time.Duration `json:"load_timeout" usage:"单个 key 调用 getter 的超时时间 超时后所有等待的请求返回错误 0 不限制"`
+	LoadTimeouts []string      `json:"load_timeouts" usage:"按 key 前缀设置调用 getter 的超时时间 格式 前缀=时长 最长前缀优先 未匹配的 key 使用 load_timeout"`
+}
+
+//master 与节点共用的配置
+type Config struct {
+	Cache         CacheConfig     `json:"cache"`
+	Replicas      int             `json:"replicas" usage:"一致性hash 每个节点的虚拟节点数"`
+	BasePath      string          `json:"base_path" usage:"节点服务的路径前缀 集群内需一致"`
+	HeartBeat     DetectorOptions `json:"heartbeat"`
+	Client        ClientOptions   `json:"client"`
+	Breaker       BreakerOptions  `json:"breaker"`
+	FallbackNodes int             `json:"fallback_nodes" usage:"get 请求在节点不可用时尝试的备用节点数 备用节点只返回本地已有或由 getter 加载的数据 不保存副本"`
+	TLS           TLSConfig       `json:"tls"`
+	Auth          AuthConfig      `json:"auth"`
+	RateLimit     RateLimitConfig `json:"ratelimit"`
+	Memory        MemoryConfig    `json:"memory"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Cache:         CacheConfig{MaxBytes: 64 << 20, Engine: engineLRU, Compression: codecNone, CompressMinSize: 1024, DiskMaxBytes: 1 << 30, NegativeMaxEntries: 10000},
+		Replicas:      defaultReplicas,
+		BasePath:      defaultBasePath,
+		HeartBeat:     DefaultDetectorOptions,
+		Client:        DefaultClientOptions,
+		Breaker:       DefaultBreakerOptions,
+		FallbackNodes: defaultFallbackNodes,
+		TLS:           DefaultTLSConfig,
+		Auth:          DefaultAuthConfig,
+		RateLimit:     DefaultRateLimitConfig,
+		Memory:        DefaultMemoryConfig,
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.Cache.MaxBytes < 0 {
+		return fmt.Errorf("cache.max_bytes must not be negative")
+	}
+	if c.Cache.Engine != "" && c.Cache.Engine != engineLRU && c.Cache.Engine != engineArena {
+		return fmt.Errorf("cache.engine must be lru or arena")
+	}
+	if c.Cache.Engine == engineArena && c.Cache.MaxBytes == 0 {
+		return fmt.Errorf("cache.engine arena requires cache.max_bytes")
+	}
+	if _, ok := codecByName(c.Cache.Compression); !ok && c.Cache.Compression != "" {
+		return fmt.Errorf("unknown cache.compression %q", c.Cache.Compression)
+	}
+	if c.Cache.CompressMinSize < 0 {
+		return fmt.Errorf("cache.compress_min_size must not be negative")
+	}
+	if c.Cache.DiskDir != "" && c.Cache.DiskMaxBytes <= 0 {
+		return fmt.Errorf("cache.disk_max_bytes must be positive when cache.disk_dir is set")
+	}
+	if c.Cache.NegativeTTL < 0 || c.Cache.ErrorTTL < 0 || c.Cache.NegativeMaxEntries < 0 {
+		return fmt.Errorf("cache.negative_ttl, cache.error_ttl and cache.negative_max_entries must not be negative")
+	}
+	if c.Cache.SoftTTL < 0 || c.Cache.HardTTL < 0 {
+		return fmt.Errorf("cache.soft_ttl and cache.hard_ttl must not be negative")
+	}
+	if c.Cache.SoftTTL > 0 && c.Cache.HardTTL > 0 && c.Cache.SoftTTL >= c.Cache.HardTTL {
+		return fmt.Errorf("cache.soft_ttl must be less than cache.hard_ttl")
+	}
+	if c.Cache.LoadTimeout < 0 {
+		return fmt.Errorf("cache.load_timeout must not be negative")
+	}
+	if _, err := parseLoadTimeouts(c.Cache.LoadTimeouts); err != nil {
+		return fmt.Errorf("cache.load_timeouts: %v", err)
+	}
+	if c.Replicas < 1 {
+		return fmt.Errorf("replicas must be at least 1")
+	}
+	if !strings.HasPrefix(c.BasePath, "/") {
+		return fmt.Errorf("base_path must start with /")
+	}
+	if c.HeartBeat.Interval <= 0 || c.HeartBeat.Timeout <= 0 {
+		return fmt.Errorf("heartbeat.interval and heartbeat.timeout must be positive")
+	}
+	if c.HeartBeat.FailureThreshold < 1 {
+		return fmt.Errorf("heartbeat.failure_threshold must be at least 1")
+	}
+	if c.HeartBeat.PhiThreshold < 0 || c.HeartBeat.WindowSize < 0 {
+		return fmt.Errorf("heartbeat.phi_threshold and heartbeat.window_size must not be negative")
+	}
+	if c.Client.DialTimeout < 0 || c.Client.RequestTimeout < 0 || c.Client.MaxRetries < 0 || c.Client.RetryBackoff < 0 {
+		return fmt.Errorf("client timeouts and retries must not be negative")
+	}
+	if c.Breaker.FailureThreshold < 0 || c.Breaker.OpenTimeout < 0 {
+		return fmt.Errorf("breaker.failure_threshold and breaker.open_timeout must not be negative")
+	}
+	if c.Breaker.FailureThreshold > 0 && c.Breaker.HalfOpenMaxRequests < 1 {
+		return fmt.Errorf("breaker.half_open_max_requests must be at least 1")
+	}
+	if c.FallbackNodes < 0 {
+		return fmt.Errorf("fallback_nodes must not be negative")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.TLS.ClientAuth && (!c.TLS.Enabled() || c.TLS.CAFile == "") {
+		return fmt.Errorf("tls.client_auth requires tls.cert_file and tls.ca_file")
+	}
+	if c.TLS.ReloadInterval < 0 {
+		return fmt.Errorf("tls.reload_interval must not be negative")
+	}
+	if _, err := newAuthenticator(c.Auth); err != nil {
+		return err
+	}
+	if c.Auth.Enabled() && c.Auth.InternalToken == "" {
+		return fmt.Errorf("auth.internal_token is required when auth is enabled")
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+	if err := c.Memory.validate(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ylt94/mycache/lru"
+)
+
+//各类型元素的额外内存开销估算(字符串头、map 桶等)
+const (
+	hashFieldOverhead = 48
+	listElemOverhead  = 16
+	setMemberOverhead = 24
+)
+
+//hash 类型
+type HashView struct {
+	m    map[string]string
+	size int
+}
+
+func (v *HashView) Len() int {
+	return v.size
+}
+
+//list 类型
+type ListView struct {
+	l    []string
+	size int
+}
+
+func (v *ListView) Len() int {
+	return v.size
+}
+
+//set 类型
+type SetView struct {
+	m    map[string]struct{}
+	size int
+}
+
+func (v *SetView) Len() int {
+	return v.size
+}
+
+func newHash() lru.Value { return &HashView{m: make(map[string]string)} }
+func newList() lru.Value { return &ListView{} }
+func newSet() lru.Value  { return &SetView{m: make(map[string]struct{})} }
+
+//修改 hash 类型 key
+func (g *mcache) mutateHash(key string, create bool, fn func(h *HashView) (changed bool)) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	var c func() lru.Value
+	if create {
+		c = newHash
+		g.negative.del(key)
+	}
+	return g.baseCache.mutate(key, c, func(v lru.Value) (bool, bool, error) {
+		h, ok := v.(*HashView)
+		if !ok {
+			return false, false, ErrWrongType
+		}
+		return fn(h), len(h.m) == 0, nil
+	})
+}
+
+//返回是否新增了 field
+func (g *mcache) HSet(key string, field string, value string) (added bool, err error) {
+	err = g.mutateHash(key, true, func(h *HashView) bool {
+		if old, ok := h.m[field]; ok {
+			h.size += len(value) - len(old)
+		} else {
+			h.size += len(field) + len(value) + hashFieldOverhead
+			added = true
+		}
+		h.m[field] = value
+		return true
+	})
+	return
+}
+
+func (g *mcache) HGet(key string, field string) (value string, ok bool, err error) {
+	err = g.mutateHash(key, false, func(h *HashView) bool {
+		value, ok = h.m[field]
+		return false
+	})
+	return
+}
+
+//返回是否删除了 field
+func (g *mcache) HDel(key string, field string) (deleted bool, err error) {
+	err = g.mutateHash(key, false, func(h *HashView) bool {
+		if old, ok := h.m[field]; ok {
+			h.size -= len(field) + len(old) + hashFieldOverhead
+			delete(h.m, field)
+			deleted = true
+		}
+		return deleted
+	})
+	return
+}
+
+func (g *mcache) HGetAll(key string) (all map[string]string, err error) {
+	all = make(map[string]string)
+	err = g.mutateHash(key, false, func(h *HashView) bool {
+		for k, v := range h.m {
+			all[k] = v
+		}
+		return false
+	})
+	return
+}
+
+//修改 list 类型 key
+func (g *mcache) mutateList(key string, create bool, fn func(l *ListView) (changed bool)) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	var c func() lru.Value
+	if create {
+		c = newList
+		g.negative.del(key)
+	}
+	return g.baseCache.mutate(key, c, func(v lru.Value) (bool, bool, error) {
+		l, ok := v.(*ListView)
+		if !ok {
+			return false, false, ErrWrongType
+		}
+		return fn(l), len(l.l) == 0, nil
+	})
+}
+
+//left 为 true 时从头部插入 返回插入后的长度
+func (g *mcache) Push(key string, left bool, values ...string) (n int, err error) {
+	err = g.mutateList(key, true, func(l *ListView) bool {
+		if left {
+			//依次插入头部 最后一个值在最前面 一次复制原有元素
+			head := make([]string, len(values), len(values)+len(l.l))
+			for i, v := range values {
+				head[len(values)-1-i] = v
+			}
+			l.l = append(head, l.l...)
+		} else {
+			l.l = append(l.l, values...)
+		}
+		for _, v := range values {
+			l.size += len(v) + listElemOverhead
+		}
+		n = len(l.l)
+		return len(values) > 0
+	})
+	return
+}
+
+//left 为 true 时从头部弹出
+func (g *mcache) Pop(key string, left bool) (value string, ok bool, err error) {
+	err = g.mutateList(key, false, func(l *ListView) bool {
+		if len(l.l) == 0 {
+			return false
+		}
+		if left {
+			value, l.l = l.l[0], l.l[1:]
+		} else {
+			value, l.l = l.l[len(l.l)-1], l.l[:len(l.l)-1]
+		}
+		l.size -= len(value) + listElemOverhead
+		ok = true
+		return true
+	})
+	return
+}
+
+//闭区间 支持负数下标
+func (g *mcache) Range(key string, start int, stop int) (values []string, err error) {
+	values = make([]string, 0)
+	err = g.mutateList(key, false, func(l *ListView) bool {
+		n := len(l.l)
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		if start <= stop {
+			values = append(values, l.l[start:stop+1]...)
+		}
+		return false
+	})
+	return
+}
+
+//修改 set 类型 key
+func (g *mcache) mutateSet(key string, create bool, fn func(s *SetView) (changed bool)) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	var c func() lru.Value
+	if create {
+		c = newSet
+		g.negative.del(key)
+	}
+	return g.baseCache.mutate(key, c, func(v lru.Value) (bool, bool, error) {
+		s, ok := v.(*SetView)
+		if !ok {
+			return false, false, ErrWrongType
+		}
+		return fn(s), len(s.m) == 0, nil
+	})
+}
+
+//返回新增的成员数
+func (g *mcache) SAdd(key string, members ...string) (n int, err error) {
+	err = g.mutateSet(key, true, func(s *SetView) bool {
+		for _, m := range members {
+			if _, ok := s.m[m]; !ok {
+				s.m[m] = struct{}{}
+				s.size += len(m) + setMemberOverhead
+				n++
+			}
+		}
+		return n > 0
+	})
+	return
+}
+
+//返回删除的成员数
+func (g *mcache) SRem(key string, members ...string) (n int, err error) {
+	err = g.mutateSet(key, false, func(s *SetView) bool {
+		for _, m := range members {
+			if _, ok := s.m[m]; ok {
+				delete(s.m, m)
+				s.size -= len(m) + setMemberOverhead
+				n++
+			}
+		}
+		return n > 0
+	})
+	return
+}
+
+func (g *mcache) SIsMember(key string, member string) (ok bool, err error) {
+	err = g.mutateSet(key, false, func(s *SetView) bool {
+		_, ok = s.m[member]
+		return false
+	})
+	return
+}
+
+//按字典序返回所有成员
+func (g *mcache) SMembers(key string) (members []string, err error) {
+	members = make([]string, 0)
+	err = g.mutateSet(key, false, func(s *SetView) bool {
+		for m := range s.m {
+			members = append(members, m)
+		}
+		return false
+	})
+	sort.Strings(members)
+	return
+}
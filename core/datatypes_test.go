@@ -0,0 +1,188 @@
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+
+	if added, err := g.HSet("h", "a", "1"); !added || err != nil {
+		t.Fatalf("HSet new field = %v, %v; want true, nil", added, err)
+	}
+	if added, _ := g.HSet("h", "a", "22"); added {
+		t.Fatal("HSet existing field: added = true; want false")
+	}
+	g.HSet("h", "b", "3")
+	if v, ok, _ := g.HGet("h", "a"); !ok || v != "22" {
+		t.Fatalf("HGet(a) = %q, %v; want 22, true", v, ok)
+	}
+	if _, ok, _ := g.HGet("h", "x"); ok {
+		t.Fatal("HGet missing field: ok = true")
+	}
+	all, _ := g.HGetAll("h")
+	if want := map[string]string{"a": "22", "b": "3"}; !reflect.DeepEqual(all, want) {
+		t.Fatalf("HGetAll = %v; want %v", all, want)
+	}
+	//大小随 field 和 value 变化
+	if got, want := hashSize(t, g, "h"), 1+2+1+1+2*hashFieldOverhead; got != want {
+		t.Fatalf("hash size = %d; want %d", got, want)
+	}
+
+	if deleted, _ := g.HDel("h", "a"); !deleted {
+		t.Fatal("HDel existing field: deleted = false")
+	}
+	if deleted, _ := g.HDel("h", "a"); deleted {
+		t.Fatal("HDel missing field: deleted = true")
+	}
+	//最后一个 field 删除后 key 被删除
+	g.HDel("h", "b")
+	if _, ok := g.baseCache.lookup("h"); ok {
+		t.Fatal("empty hash still stored")
+	}
+}
+
+func TestList(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+
+	//与 redis 一致 LPUSH a b c 后顺序为 c b a
+	if n, _ := g.Push("l", true, "a", "b", "c"); n != 3 {
+		t.Fatalf("LPUSH len = %d; want 3", n)
+	}
+	if n, _ := g.Push("l", false, "x", "y"); n != 5 {
+		t.Fatalf("RPUSH len = %d; want 5", n)
+	}
+	g.Push("l", true, "0")
+
+	cases := []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, []string{"0", "c", "b", "a", "x", "y"}},
+		{1, 2, []string{"c", "b"}},
+		{-2, -1, []string{"x", "y"}},
+		{-100, 0, []string{"0"}},
+		{4, 100, []string{"x", "y"}},
+		{3, 1, []string{}},
+		{10, 20, []string{}},
+	}
+	for _, c := range cases {
+		got, err := g.Range("l", c.start, c.stop)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Range(%d, %d) = %v, %v; want %v", c.start, c.stop, got, err, c.want)
+		}
+	}
+
+	if v, ok, _ := g.Pop("l", true); !ok || v != "0" {
+		t.Fatalf("LPOP = %q, %v; want 0, true", v, ok)
+	}
+	if v, ok, _ := g.Pop("l", false); !ok || v != "y" {
+		t.Fatalf("RPOP = %q, %v; want y, true", v, ok)
+	}
+	if got, want := listSize(t, g, "l"), 4*(1+listElemOverhead); got != want {
+		t.Fatalf("list size = %d; want %d", got, want)
+	}
+	for i := 0; i < 4; i++ {
+		g.Pop("l", true)
+	}
+	if _, ok, _ := g.Pop("l", true); ok {
+		t.Fatal("Pop on empty list: ok = true")
+	}
+	if _, ok := g.baseCache.lookup("l"); ok {
+		t.Fatal("empty list still stored")
+	}
+}
+
+//多次 LPUSH 后顺序正确
+func TestListLeftPushMany(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+
+	var want []string
+	for i := 0; i < 100; i++ {
+		s := strconv.Itoa(i)
+		g.Push("l", true, s)
+		want = append([]string{s}, want...)
+	}
+	if got, _ := g.Range("l", 0, -1); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Range after LPUSH = %v; want %v", got, want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+
+	if n, _ := g.SAdd("s", "b", "a", "b"); n != 2 {
+		t.Fatalf("SAdd = %d; want 2", n)
+	}
+	if n, _ := g.SAdd("s", "a", "c"); n != 1 {
+		t.Fatalf("SAdd with existing member = %d; want 1", n)
+	}
+	if ok, _ := g.SIsMember("s", "c"); !ok {
+		t.Fatal("SIsMember(c) = false")
+	}
+	if ok, _ := g.SIsMember("s", "z"); ok {
+		t.Fatal("SIsMember(z) = true")
+	}
+	if m, _ := g.SMembers("s"); !reflect.DeepEqual(m, []string{"a", "b", "c"}) {
+		t.Fatalf("SMembers = %v; want [a b c]", m)
+	}
+	if n, _ := g.SRem("s", "a", "z"); n != 1 {
+		t.Fatalf("SRem = %d; want 1", n)
+	}
+	g.SRem("s", "b", "c")
+	if _, ok := g.baseCache.lookup("s"); ok {
+		t.Fatal("empty set still stored")
+	}
+}
+
+//不同类型的操作互不兼容 读取不存在的 key 不创建
+func TestWrongType(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+
+	g.Set("str", "v")
+	g.HSet("h", "f", "v")
+	if _, err := g.HSet("str", "f", "v"); err != ErrWrongType {
+		t.Fatalf("HSet on string = %v; want ErrWrongType", err)
+	}
+	if _, err := g.Push("h", true, "v"); err != ErrWrongType {
+		t.Fatalf("Push on hash = %v; want ErrWrongType", err)
+	}
+	if _, err := g.SAdd("h", "v"); err != ErrWrongType {
+		t.Fatalf("SAdd on hash = %v; want ErrWrongType", err)
+	}
+	if _, err := g.Get("h"); err != ErrWrongType {
+		t.Fatalf("Get on hash = %v; want ErrWrongType", err)
+	}
+
+	if m, err := g.SMembers("missing"); err != nil || len(m) != 0 {
+		t.Fatalf("SMembers(missing) = %v, %v; want empty", m, err)
+	}
+	if _, ok := g.baseCache.lookup("missing"); ok {
+		t.Fatal("read created the key")
+	}
+}
+
+func hashSize(t *testing.T, g *mcache, key string) int {
+	t.Helper()
+	v, ok := g.baseCache.lookup(key)
+	if !ok {
+		t.Fatalf("%s not stored", key)
+	}
+	return v.(*HashView).Len()
+}
+
+func listSize(t *testing.T, g *mcache, key string) int {
+	t.Helper()
+	v, ok := g.baseCache.lookup(key)
+	if !ok {
+		t.Fatalf("%s not stored", key)
+	}
+	return v.(*ListView).Len()
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//故障检测配置
+type DetectorOptions struct {
+	Interval         time.Duration `json:"interval" usage:"心跳间隔"`
+	Timeout          time.Duration `json:"timeout" usage:"单次心跳超时"`
+	FailureThreshold int           `json:"failure_threshold" usage:"连续失败多少次判定为 dead"`
+	PhiThreshold     float64       `json:"phi_threshold" usage:"phi 值超过该阈值且心跳失败时提前判定为 dead 0 不启用"`
+	WindowSize       int           `json:"window_size" usage:"phi 计算使用的心跳间隔样本数"`
+}
+
+var DefaultDetectorOptions = DetectorOptions{
+	Interval:         5 * time.Second,
+	Timeout:          5 * time.Second,
+	FailureThreshold: 3,
+	PhiThreshold:     8,
+	WindowSize:       100,
+}
+
+type nodeState string
+
+//dead 节点的状态保留时间 之后不再出现在 action=nodes 中
+const deadStatusTTL = 10 * time.Minute
+
+const (
+	nodeAlive   nodeState = "alive"
+	nodeSuspect nodeState = "suspect"
+	nodeDead    nodeState = "dead"
+)
+
+//单个节点的故障检测器 失败一次进入 suspect 连续失败达到阈值或 phi 超过阈值进入 dead
+type failureDetector struct {
+	mu        sync.Mutex
+	opts      DetectorOptions
+	state     nodeState
+	failures  int
+	lastOK    time.Time
+	intervals []float64 //成功心跳的间隔 单位秒
+	changedAt time.Time
+}
+
+func newFailureDetector(o DetectorOptions) *failureDetector {
+	now := time.Now()
+	return &failureDetector{opts: o, state: nodeAlive, lastOK: now, changedAt: now}
+}
+
+//记录一次心跳结果 返回新的状态
+func (d *failureDetector) report(err error, now time.Time) nodeState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state == nodeDead {
+		return d.state
+	}
+	if err == nil {
+		d.intervals = append(d.intervals, now.Sub(d.lastOK).Seconds())
+		if n := d.opts.WindowSize; n > 0 && len(d.intervals) > n {
+			d.intervals = d.intervals[len(d.intervals)-n:]
+		}
+		d.lastOK = now
+		d.failures = 0
+		d.setState(nodeAlive, now)
+		return d.state
+	}
+
+	d.failures++
+	if d.failures >= d.opts.FailureThreshold ||
+		(d.opts.PhiThreshold > 0 && d.phi(now) >= d.opts.PhiThreshold) {
+		d.setState(nodeDead, now)
+	} else {
+		d.setState(nodeSuspect, now)
+	}
+	return d.state
+}
+
+func (d *failureDetector) setState(s nodeState, now time.Time) {
+	if d.state != s {
+		d.state = s
+		d.changedAt = now
+	}
+}
+
+//phi accrual 按成功心跳间隔的正态分布估算 now 时仍未收到心跳的可疑程度
+func (d *failureDetector) phi(now time.Time) float64 {
+	if len(d.intervals) < 2 {
+		return 0
+	}
+	var sum, sq float64
+	for _, v := range d.intervals {
+		sum += v
+	}
+	mean := sum / float64(len(d.intervals))
+	for _, v := range d.intervals {
+		sq += (v - mean) * (v - mean)
+	}
+	std := math.Sqrt(sq / float64(len(d.intervals)))
+	//间隔非常稳定时标准差过小 设置下限避免误判
+	if floor := mean / 4; std < floor {
+		std = floor
+	}
+
+	elapsed := now.Sub(d.lastOK).Seconds()
+	pLater := 0.5 * math.Erfc((elapsed-mean)/(std*math.Sqrt2))
+	if pLater < 1e-300 {
+		pLater = 1e-300
+	}
+	return -math.Log10(pLater)
+}
+
+//节点状态 通过 action=nodes 查看
+type nodeStatus struct {
+	Name          string    `json:"name"`
+	State         nodeState `json:"state"`
+	Failures      int       `json:"failures"`
+	Phi           float64   `json:"phi"`
+	LastHeartBeat time.Time `json:"last_heartbeat"`
+	Since         time.Time `json:"since"`
+	Breaker       string    `json:"breaker,omitempty"`
+}
+
+func (d *failureDetector) status(name string, now time.Time) nodeStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return nodeStatus{
+		Name:          name,
+		State:         d.state,
+		Failures:      d.failures,
+		Phi:           d.phi(now),
+		LastHeartBeat: d.lastOK,
+		Since:         d.changedAt,
+	}
+}
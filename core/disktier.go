@@ -0,0 +1,127 @@
+package core
+
+import (
+	"github.com/ylt94/mycache/disk"
+	"github.com/ylt94/mycache/logger"
+	"github.com/ylt94/mycache/lru"
+)
+
+//磁盘层: 内存淘汰的字符串类型数据写入本地文件 读取时先查内存再查磁盘 命中后移回内存
+//同一个 key 只会存在于内存或磁盘其中一处 tag 随数据保留
+
+//磁盘层统计
+type diskCounters struct {
+	spills int64 //从内存写入磁盘的条数
+	hits   int64 //从磁盘移回内存的条数
+}
+
+func openDisk(c *cache, cfg CacheConfig) {
+	if cfg.DiskDir == "" {
+		return
+	}
+	d, err := disk.Open(cfg.DiskDir, cfg.DiskMaxBytes, c.untag)
+	if err != nil {
+		panic("open disk tier:" + err.Error())
+	}
+	c.disk = d
+}
+
+//内存淘汰时连同版本号写入磁盘 写入失败、不是字符串类型或磁盘层已关闭时丢弃
+func (c *cache) onEvicted(key string, value lru.Value, version uint64) {
+	if b, ok := value.(ByteView); ok && c.disk != nil {
+		err := c.disk.Put(key, b.marshal(), version)
+		if err == nil {
+			c.diskCount.spills++
+			return
+		}
+		logger.Debug("[cache] spill to disk failed", logger.F("key", key), logger.F("err", err))
+	}
+	c.untag(key)
+}
+
+//加写锁 key 在磁盘中时先在锁外读取并移回内存
+//移回后到加锁前可能再次被淘汰到磁盘 此时重新读取
+func (c *cache) lockPromoted(key string) {
+	for {
+		c.promote(key)
+		c.mu.Lock()
+		if c.disk == nil || !c.disk.Has(key) {
+			return
+		}
+		c.mu.Unlock()
+	}
+}
+
+//key 在磁盘中时移回内存 读取文件时不持有锁 调用时不能持有锁
+func (c *cache) promote(key string) {
+	c.mu.RLock()
+	d := c.disk
+	c.mu.RUnlock()
+	if d == nil || !d.Has(key) {
+		return
+	}
+	b, ver, ok, err := d.GetWithVersion(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	//读取期间磁盘层被关闭 或数据被覆盖、删除时放弃 由调用方重试
+	if c.disk != d {
+		return
+	}
+	if err != nil {
+		logger.Warn("[cache] read disk failed", logger.F("key", key), logger.F("err", err))
+		if d.Del(key) {
+			c.untag(key)
+		}
+		return
+	}
+	if !ok || !d.DelIfVersion(key, ver) {
+		return
+	}
+	c.diskCount.hits++
+	c.lazyInit()
+	//保留淘汰前的版本号 淘汰前读取的版本号仍可用于 CAS
+	c.lru.AddWithVersion(key, unmarshalView(b), ver)
+}
+
+//删除磁盘中的数据 需持有写锁
+func (c *cache) delDisk(key string) bool {
+	if c.disk == nil || !c.disk.Del(key) {
+		return false
+	}
+	c.untag(key)
+	return true
+}
+
+func (c *cache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disk == nil {
+		return nil
+	}
+	err := c.disk.Close()
+	c.disk = nil
+	return err
+}
+
+type diskStats struct {
+	Items  int64
+	Bytes  int64
+	Spills int64
+	Hits   int64
+}
+
+func (c *cache) diskStats() diskStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.disk == nil {
+		return diskStats{}
+	}
+	return diskStats{
+		Items:  int64(c.disk.Len()),
+		Bytes:  c.disk.UsedBytes(),
+		Spills: c.diskCount.spills,
+		Hits:   c.diskCount.hits,
+	}
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+//内存只能放下几条数据的缓存 淘汰的数据写入临时目录
+func newDiskCache(t *testing.T, engine string) (*mcache, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "mycache-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1024, Engine: engine, DiskDir: dir, DiskMaxBytes: 1 << 20}, nil)
+	return g, func() {
+		g.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+//写入其他 key 直到 key 被淘汰到磁盘
+func spill(t *testing.T, g *mcache, key string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		g.baseCache.mu.RLock()
+		onDisk := g.baseCache.disk.Has(key)
+		g.baseCache.mu.RUnlock()
+		if onDisk {
+			return
+		}
+		g.Set("filler"+strconv.Itoa(i), "0123456789012345678901234567890123456789")
+	}
+	t.Fatalf("%s not spilled to disk", key)
+}
+
+//淘汰到磁盘再移回内存后版本号不变 之前读取的版本号仍可用于 CAS
+func TestSpillKeepsVersion(t *testing.T) {
+	for _, engine := range []string{engineLRU, engineArena} {
+		t.Run(engine, func(t *testing.T) {
+			g, done := newDiskCache(t, engine)
+			defer done()
+
+			ver, err := g.SetNX("k", "v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			spill(t, g, "k")
+			b, got, err := g.GetWithVersion("k")
+			if err != nil || string(b) != "v1" || got != ver {
+				t.Fatalf("GetWithVersion after promote = %q, %d, %v; want v1, %d", b, got, err, ver)
+			}
+
+			spill(t, g, "k")
+			ver2, err := g.CompareAndSet("k", "v2", ver)
+			if err != nil {
+				t.Fatalf("CAS with version read before spill = %v; want nil", err)
+			}
+			if ver2 <= ver {
+				t.Fatalf("version after CAS = %d; want greater than %d", ver2, ver)
+			}
+
+			spill(t, g, "k")
+			if _, err := g.CompareAndSet("k", "v3", ver); err != ErrVersionMismatch {
+				t.Fatalf("CAS with stale version after spill = %v; want ErrVersionMismatch", err)
+			}
+			spill(t, g, "k")
+			if err := g.CompareAndDel("k", ver2); err != nil {
+				t.Fatalf("CompareAndDel after spill = %v; want nil", err)
+			}
+			if b, _ := g.Get("k"); len(b) != 0 {
+				t.Fatalf("Get after CompareAndDel = %q; want empty", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+//批量删除结果
+type invalidateResult struct {
+	Deleted int               `json:"deleted"`
+	Nodes   map[string]int    `json:"nodes"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+//节点 deltag/delprefix 命令 返回删除条数
+func (h *NodeServer) serveInvalidate(w http.ResponseWriter, act string, values url.Values) {
+	var n int
+	var err error
+	if act == "deltag" {
+		n, err = h.mainCache.DelByTag(values.Get("tag"))
+	} else {
+		n, err = h.mainCache.DelByPrefix(values.Get("prefix"))
+	}
+	if err != nil {
+		w.Write([]byte("cache " + act + " error:" + err.Error()))
+		return
+	}
+	w.Write([]byte(strconv.Itoa(n)))
+}
+
+//广播给所有节点 汇总删除条数
+func (m *service) serveInvalidate(w http.ResponseWriter, r *http.Request) {
+	res := invalidateResult{Nodes: make(map[string]int)}
+	for name, reply := range m.broadcast(r.Context(), r.URL.Query()) {
+		var n int
+		err := reply.err
+		if err == nil {
+			n, err = strconv.Atoi(string(reply.body))
+			if err != nil {
+				err = errors.New(string(reply.body))
+			}
+		}
+		if err != nil {
+			if res.Errors == nil {
+				res.Errors = make(map[string]string)
+			}
+			res.Errors[name] = err.Error()
+			continue
+		}
+		res.Nodes[name] = n
+		res.Deleted += n
+	}
+	writeJSON(w, res)
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ylt94/mycache/logger"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+//访问日志采样 默认不输出
+var accessLogSampler = logger.NewSampler(0)
+
+//设置访问日志采样比例 取值 0~1
+func SetAccessLogSampleRate(rate float64) {
+	accessLogSampler.SetRate(rate)
+}
+
+//读取或生成请求 ID 写入 context 和响应头
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = logger.NewRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(logger.WithRequestID(r.Context(), id))
+}
+
+//采样输出访问日志
+func accessLog(r *http.Request, component string, start time.Time) {
+	if !accessLogSampler.Sample() {
+		return
+	}
+	values := r.URL.Query()
+	logger.FromContext(r.Context()).Info("access",
+		logger.F("component", component),
+		logger.F("remote", r.RemoteAddr),
+		logger.F("action", values.Get("action")),
+		logger.F("key", values.Get("key")),
+		logger.F("duration", time.Since(start)),
+	)
+}
@@ -2,65 +2,159 @@ package core
 
 import (
 	"fmt"
-	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ylt94/mycache/consistenthash"
+	"github.com/ylt94/mycache/logger"
+	"github.com/ylt94/mycache/trace"
 )
 
 type service struct {
 	addr    string
 	mserver *master
+	limiter *rateLimiter
 }
 type master struct {
-	addr              string
-	nodeGetters       map[string]*NodeGetter //注册节点
-	mu                sync.RWMutex           //hash 锁
-	hash              *consistenthash.Map    //一致性hash
-	heartBeatInterval time.Duration          //心跳检测间隔时间
-	dieNodes          chan string            //挂掉节点处理队列
+	addr        string
+	nodeGetters map[string]*NodeGetter //注册节点
+	mu          sync.RWMutex           //hash 锁
+	hash        *consistenthash.Map    //一致性hash
+	cfg         Config
+	detectors   map[string]*failureDetector
+	staticNodes map[string]bool //静态配置的节点
+	metrics     *masterMetrics
+	auth        *authenticator
+	client      *http.Client //所有节点共用
 }
 
-var defaultHeartBeatInterval time.Duration = 5
-var defaultHeartBeatTimeOut time.Duration = 5 * time.Second
-
-var defaultDieNodeChanCap int = 5
+const defaultFallbackNodes = 1
 
 func NewService(addr string, mserver *master) *service {
 	return &service{
 		addr:    addr,
 		mserver: mserver,
+		limiter: newRateLimiter(mserver.cfg.RateLimit),
 	}
 }
 
-func NewMaster(addr string) *master {
-	return &master{
-		addr:              addr,
-		hash:              consistenthash.New(1, nil),
-		heartBeatInterval: time.Second * defaultHeartBeatInterval,
-		dieNodes:          make(chan string, defaultDieNodeChanCap),
+func NewMaster(addr string, cfg Config) *master {
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		panic(err.Error())
+	}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		panic(err.Error())
+	}
+	m := &master{
+		addr:      addr,
+		cfg:       cfg,
+		hash:      consistenthash.New(cfg.Replicas, nil),
+		detectors: make(map[string]*failureDetector),
+		auth:      auth,
+		client:    client,
 	}
+	m.metrics = newMasterMetrics(m)
+	return m
 }
 
 //对 client端的server
 func (m *service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(trace.Extract(r.Context(), trace.HeaderCarrier(r.Header)), "service.ServeHTTP")
+	defer span.End()
+	r = withRequestID(w, r.WithContext(ctx))
 	values := r.URL.Query()
+	start := time.Now()
+	defer accessLog(r, "service", start)
+	defer observeSince(m.mserver.metrics.latency, strings.ToLower(values.Get("action")), start)
+	identity, ok := m.mserver.auth.verify(w, r, values.Get("action"), authScope(r))
+	if !ok {
+		return
+	}
+	if strings.ToLower(values.Get("action")) == "ratelimit" {
+		m.serveRateLimit(w, values)
+		return
+	}
+	if wait, reason := m.limiter.allow(clientIdentity(r, identity), values.Get("key"), writeSize(values.Get("action"), values)); wait > 0 {
+		m.mserver.metrics.rateLimited.With(reason).Inc()
+		writeRateLimited(w, wait, reason)
+		return
+	}
+	if strings.ToLower(values.Get("action")) == "scan" {
+		m.serveScan(w, r)
+		return
+	}
+	if act := strings.ToLower(values.Get("action")); act == "deltag" || act == "delprefix" {
+		m.serveInvalidate(w, r)
+		return
+	}
+	if strings.ToLower(values.Get("action")) == "stats" {
+		m.serveStats(w, r)
+		return
+	}
+
 	key := values.Get("key")
 	if key == "" {
 		w.Write([]byte("key is required"))
+		return
 	}
 
-	nodeGetter, err := m.mserver.getNode(key)
-	if err != nil {
-		w.Write([]byte("get node err:" + err.Error()))
+	span.SetAttr("key", key)
+	//get 请求在节点熔断或失败时转到环上的下一个节点 其他请求直接失败
+	//备用节点没有副本 只返回本地已有的数据或通过 getter 加载但不写入的数据
+	read := strings.ToLower(values.Get("action")) == "get"
+	candidates := m.mserver.candidates(key, read)
+	if len(candidates) == 0 {
+		w.Write([]byte("get node err:no cache node"))
+		return
+	}
+	var lastErr error
+	for i, nodeGetter := range candidates {
+		if !nodeGetter.breaker.allow() {
+			m.mserver.metrics.breakerRejections.Inc()
+			continue
+		}
+		span.SetAttr("node", nodeGetter.baseURL)
+		fallback := i > 0
+		res, err := nodeGetter.forward(r, fallback)
+		//key 所属节点的 4xx 直接返回 备用节点没有数据时继续尝试下一个
+		if err == nil || (res != nil && !(fallback && res.status == http.StatusNotFound)) {
+			res.writeTo(w)
+			return
+		}
+		lastErr = err
+		span.SetError(err)
+		logger.FromContext(r.Context()).Error("node error", logger.F("node", nodeGetter.baseURL), logger.F("err", err))
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+	if lastErr == nil {
+		http.Error(w, "node unavailable: circuit open", http.StatusServiceUnavailable)
+		return
 	}
+	http.Error(w, "node error:"+lastErr.Error(), http.StatusBadGateway)
+}
 
-	err = nodeGetter.HandleByHTTP(w, r)
-	if err != nil {
-		log.Println("node error: " + err.Error())
+//key 对应的节点 读请求额外返回环上后续的节点作为备用 备用节点不保存该 key 的副本
+func (m *master) candidates(key string, read bool) []*NodeGetter {
+	n := 1
+	if read {
+		n += m.cfg.FallbackNodes
 	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	getters := make([]*NodeGetter, 0, n)
+	for _, name := range m.hash.GetN(key, n) {
+		if getter, ok := m.nodeGetters[name]; ok {
+			getters = append(getters, getter)
+		}
+	}
+	return getters
 }
 
 func (m *master) registerNode(name string) error {
@@ -73,11 +167,60 @@ func (m *master) registerNode(name string) error {
 	if m.nodeGetters == nil {
 		m.nodeGetters = make(map[string]*NodeGetter)
 	}
-	m.nodeGetters[name] = &NodeGetter{baseURL: name}
+	m.nodeGetters[name] = newNodeGetter(name, m.cfg, m.client)
+	m.detectors[name] = newFailureDetector(m.cfg.HeartBeat)
 
 	return nil
 }
 
+//注册节点并开始心跳检测
+func (m *master) addNode(name string) error {
+	if err := m.registerNode(name); err != nil {
+		return err
+	}
+	go m.heartBeat(name)
+	return nil
+}
+
+//移除节点 dead 状态保留 deadStatusTTL 以便查询
+func (m *master) removeNode(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodeGetters[name]; !ok {
+		return
+	}
+	delete(m.nodeGetters, name)
+	//删除hash环的节点信息
+	m.hash.Delete(name)
+
+	detector := m.detectors[name]
+	time.AfterFunc(deadStatusTTL, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		//期间重新注册的节点使用新的检测器
+		if m.detectors[name] == detector {
+			delete(m.detectors, name)
+		}
+	})
+}
+
+//所有节点状态 包括已判定为 dead 的节点
+func (m *master) nodeStatuses() []nodeStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	statuses := make([]nodeStatus, 0, len(m.detectors))
+	for name, d := range m.detectors {
+		s := d.status(name, now)
+		if getter, ok := m.nodeGetters[name]; ok {
+			s.Breaker = getter.breaker.currentState().String()
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
 func (m *master) getNode(key string) (*NodeGetter, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -88,23 +231,55 @@ func (m *master) getNode(key string) (*NodeGetter, error) {
 	return nil, fmt.Errorf("no such cache node:" + name)
 }
 
+func (m *master) isStatic(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.staticNodes[name]
+}
+
+//按名称获取节点
+func (m *master) getNodeByName(name string) (*NodeGetter, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	getter, ok := m.nodeGetters[name]
+	return getter, ok
+}
+
+//按字典序返回所有节点名
+func (m *master) nodeNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.nodeGetters))
+	for name := range m.nodeGetters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 //处理节点注册
 func (m *master) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	values := r.URL.Query()
+	if !m.auth.allow(w, r, values.Get("action"), "") {
+		return
+	}
+	if strings.ToLower(values.Get("action")) == "nodes" {
+		writeJSON(w, m.nodeStatuses())
+		return
+	}
+
 	name := values.Get("name")
 	if name == "" {
 		w.Write([]byte("node's name is required"))
 		return
 	}
 
-	//注册节点
-	err := m.registerNode(name)
+	//注册节点 并开始心跳检测
+	err := m.addNode(name)
 	if err != nil {
 		w.Write([]byte(err.Error()))
 		return
 	}
-	//心跳检测
-	go m.heartBeat(name)
 
 	w.Write([]byte("success"))
 }
@@ -112,50 +287,55 @@ func (m *master) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (m *master) heartBeat(name string) {
 	m.mu.RLock()
 	node, ok := m.nodeGetters[name]
+	detector := m.detectors[name]
 	if !ok {
 		m.mu.RUnlock()
 		return
 	}
 	m.mu.RUnlock()
 
-	ticker := time.NewTicker(m.heartBeatInterval)
+	ticker := time.NewTicker(detector.opts.Interval)
+	defer ticker.Stop()
 	for range ticker.C {
-		err := node.heartBeat(defaultHeartBeatTimeOut)
+		//节点已被移除或重新注册
+		if cur, ok := m.getNodeByName(name); !ok || cur != node {
+			return
+		}
+		err := node.heartBeat(detector.opts.Timeout)
 		if err != nil {
-			//发送给另外一个协程去处理
-			m.dieNodes <- name
-			break
+			m.metrics.heartBeatFailures.Inc()
 		}
-	}
-	ticker.Stop()
-}
-
-//处理挂掉节点--单线程处理
-func (m *master) dieNodeHandler() {
-	for name := range m.dieNodes {
-		log.Println("start handle die node:", name)
-		node, _ := m.getNode(name)
-		if node != nil {
-			//再次验证
-			err := node.heartBeat(defaultHeartBeatTimeOut)
-			if err != nil {
-				m.mu.Lock()
-				delete(m.nodeGetters, name)
-				//删除hash环的节点信息
-				m.hash.Delete(name)
-				m.mu.Unlock()
+		switch detector.report(err, time.Now()) {
+		case nodeSuspect:
+			logger.Warn("node suspect", logger.F("node", name), logger.F("err", err))
+		case nodeDead:
+			logger.Warn("node dead", logger.F("node", name), logger.F("err", err))
+			m.removeNode(name)
+			if m.isStatic(name) {
+				go m.watchStatic(name)
 			}
+			return
 		}
 	}
 }
 
 func ServiceStart(srv *service) {
-	go srv.mserver.dieNodeHandler()
-
 	mx := http.NewServeMux()
 	mx.Handle("/", srv.mserver)
-	go http.ListenAndServe(srv.mserver.addr[7:], mx)
+	mx.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		serveMetrics(w, srv.mserver.metrics.registry)
+	})
+	tlsConfig := srv.mserver.cfg.TLS
+	//节点注册端口启用 mTLS 时只接受 CA 签发证书的节点
+	go func() {
+		if err := listenAndServe(srv.mserver.addr, mx, tlsConfig, true); err != nil {
+			panic(err.Error())
+		}
+	}()
 
-	http.Handle("/", srv)
-	http.ListenAndServe(srv.addr[7:], nil)
+	sx := http.NewServeMux()
+	sx.Handle("/", srv)
+	if err := listenAndServe(srv.addr, sx, tlsConfig, false); err != nil {
+		panic(err.Error())
+	}
 }
@@ -1,12 +1,23 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ylt94/mycache/logger"
 	"github.com/ylt94/mycache/singleflight"
+	"github.com/ylt94/mycache/trace"
 )
 
+//数据源 Get 未命中时调用 同一个 key 的并发加载通过 singleflight 合并 加载结果写入缓存
+//Get 不能被取消 加载超时或调用方都放弃后仍会执行完 结果被丢弃 期间同一个 key 的新请求会再次调用 Get
+//数据源可能变慢时应实现 ContextGetter 否则同一个 key 同时执行的 Get 数量没有上限
 type Getter interface {
 	Get(key string) ([]byte, error)
 }
@@ -18,11 +29,46 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+//getter 实现该接口时加载使用 GetContext 加载超时或所有调用方都放弃等待时 ctx 被取消
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+var (
+	ErrVersionMismatch = errors.New("version mismatch")
+	ErrKeyExists       = errors.New("key already exists")
+	ErrKeyNotExists    = errors.New("key not exists")
+	ErrWrongType       = errors.New("operation against a key holding the wrong kind of value")
+	//getter 返回该错误表示数据源中不存在 key 开启负缓存时会记录不存在标记
+	ErrNotFound = errors.New("not found")
+)
+
 type mcache struct {
-	id        string
-	getter    Getter
-	baseCache cache
-	loader    *singleflight.Group
+	counters        cacheCounters
+	compress        compressCounters
+	id              string
+	getter          Getter
+	baseCache       cache
+	loader          *singleflight.Group
+	negative        *negativeCache
+	codec           uint8 //写入时使用的压缩算法
+	compressMinSize int
+	ttl             ttlOptions
+	refreshMu       sync.Mutex
+	refreshing      map[string]bool //正在后台刷新的 key
+}
+
+//访问计数 原子操作
+type cacheCounters struct {
+	gets       int64
+	hits       int64
+	misses     int64
+	loads      int64 //实际调用 getter 次数
+	loadErrors int64
+	loadDedups int64 //被 singleflight 合并的加载请求
+	staleHits  int64 //超过 soft TTL 返回旧数据的次数
+	refreshes  int64 //后台刷新次数
+	staleOnErr int64 //加载失败返回旧数据的次数
 }
 
 var (
@@ -31,37 +77,219 @@ var (
 //groups = make(map[string]*Group)
 )
 
-func NewMCache(id string, cacheBytes int64, getter Getter) *mcache {
+//getter 为 nil 时未命中直接返回空值 不加载
+func NewMCache(id string, cfg CacheConfig, getter Getter) *mcache {
+	codec, ok := codecByName(cfg.Compression)
+	if !ok && cfg.Compression != "" {
+		panic("unknown compression:" + cfg.Compression)
+	}
+	timeouts, err := parseLoadTimeouts(cfg.LoadTimeouts)
+	if err != nil {
+		panic("load timeouts:" + err.Error())
+	}
 	g := &mcache{
-		id:        id,
-		getter:    getter,
-		baseCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		id:              id,
+		getter:          getter,
+		baseCache:       cache{cacheBytes: cfg.MaxBytes, engine: cfg.Engine},
+		loader:          &singleflight.Group{Timeout: cfg.LoadTimeout, KeyTimeout: timeouts.get},
+		negative:        newNegativeCache(cfg),
+		codec:           codec,
+		compressMinSize: cfg.CompressMinSize,
+		ttl:             ttlOptions{soft: cfg.SoftTTL, hard: cfg.HardTTL, staleOnError: cfg.StaleOnError},
+		refreshing:      make(map[string]bool),
 	}
+	openDisk(&g.baseCache, cfg)
+	nodeMemory.register(&g.baseCache)
 	return g
 }
 
+//按 key 前缀设置的加载超时 最长前缀优先
+type loadTimeouts []prefixTimeout
+
+type prefixTimeout struct {
+	prefix  string
+	timeout time.Duration
+}
+
+//格式 前缀=时长
+func parseLoadTimeouts(list []string) (loadTimeouts, error) {
+	res := make(loadTimeouts, 0, len(list))
+	for _, s := range list {
+		i := strings.LastIndex(s, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q: expected prefix=duration", s)
+		}
+		d, err := time.ParseDuration(s[i+1:])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%q: invalid duration", s)
+		}
+		res = append(res, prefixTimeout{prefix: s[:i], timeout: d})
+	}
+	sort.Slice(res, func(a, b int) bool { return len(res[a].prefix) > len(res[b].prefix) })
+	return res, nil
+}
+
+//key 匹配的超时时间 没有匹配时返回 0
+func (t loadTimeouts) get(key string) time.Duration {
+	for _, p := range t {
+		if strings.HasPrefix(key, p.prefix) {
+			return p.timeout
+		}
+	}
+	return 0
+}
+
+//不再使用缓存时调用 从节点内存管理中移除并删除磁盘层文件
+func (g *mcache) Close() error {
+	nodeMemory.unregister(&g.baseCache)
+	return g.baseCache.close()
+}
+
+//未命中且设置了 getter 时阻塞加载 加载成功的数据写入缓存
 func (g *mcache) Get(key string) ([]byte, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+func (g *mcache) GetContext(ctx context.Context, key string) (_ []byte, err error) {
+	ctx, span := trace.Start(ctx, "mcache.Get")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttr("key", key)
+
 	if key == "" {
 		return make([]byte, 0), fmt.Errorf("key is required")
 	}
 
+	atomic.AddInt64(&g.counters.gets, 1)
 	//从底层获取
-	if v, ok := g.baseCache.get(key); ok {
-		log.Println("[mcache] hit")
-		return v.ByteSlice(), nil
+	var stale *ByteView
+	if v, ok := g.baseCache.lookup(key); ok {
+		b, ok := v.(ByteView)
+		if !ok {
+			return make([]byte, 0), ErrWrongType
+		}
+		soft, hard := g.ttl.expired(b)
+		if !hard {
+			atomic.AddInt64(&g.counters.hits, 1)
+			span.SetAttr("hit", true)
+			if soft {
+				//超过 soft TTL 返回旧数据 后台刷新
+				atomic.AddInt64(&g.counters.staleHits, 1)
+				span.SetAttr("stale", true)
+				g.refresh(key)
+			}
+			logger.Debug("[mcache] hit", logger.F("key", key))
+			return decode(b)
+		}
+		//超过 hard TTL 等待重新加载
+		stale = &b
+		span.SetAttr("expired", true)
+	}
+	atomic.AddInt64(&g.counters.misses, 1)
+	span.SetAttr("hit", false)
+	if g.getter == nil {
+		return make([]byte, 0), nil
+	}
+	if err, ok := g.negative.get(key); ok {
+		span.SetAttr("negative", true)
+		return g.serveStale(key, stale, err)
+	}
+	b, err := g.load(ctx, key)
+	if err != nil {
+		return g.serveStale(key, stale, err)
+	}
+	return b, nil
+}
+
+//未命中时通过 getter 加载 同一个 key 的并发请求只加载一次
+func (g *mcache) load(ctx context.Context, key string) ([]byte, error) {
+	var executed int32
+	v, err, shared := g.loader.DoContext(ctx, key, func(lctx context.Context) (interface{}, error) {
+		atomic.StoreInt32(&executed, 1)
+		atomic.AddInt64(&g.counters.loads, 1)
+		_, span := trace.Start(ctx, "getter.Get")
+		span.SetAttr("key", key)
+		var bytes []byte
+		var err error
+		if cg, ok := g.getter.(ContextGetter); ok {
+			bytes, err = cg.GetContext(lctx, key)
+		} else {
+			bytes, err = g.getter.Get(key)
+		}
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			atomic.AddInt64(&g.counters.loadErrors, 1)
+			//超时或被取消的错误不缓存
+			if lctx.Err() == nil {
+				g.negative.add(key, err)
+			}
+			return nil, err
+		}
+		//已超时或被放弃 结果不会返回给调用方 也不写入缓存
+		if err := lctx.Err(); err != nil {
+			return nil, err
+		}
+		val := ByteView{b: cloneBytes(bytes)}
+		enc := g.encode(val.b)
+		enc.loaded = time.Now().UnixNano()
+		//缓存不下时仍返回加载的数据
+		g.baseCache.add(key, enc)
+		return val, nil
+	})
+	if shared && atomic.LoadInt32(&executed) == 0 {
+		atomic.AddInt64(&g.counters.loadDedups, 1)
+	}
+	if err != nil {
+		return make([]byte, 0), err
+	}
+	return v.(ByteView).ByteSlice(), nil
+}
+
+//作为备用节点读取 只读本地数据 未命中时调用 getter 但不写入缓存
+//没有数据时返回 ErrNotFound 避免空值被当作命中返回
+func (g *mcache) getNoStore(ctx context.Context, key string) ([]byte, error) {
+	if v, ok := g.baseCache.lookup(key); ok {
+		b, ok := v.(ByteView)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		return decode(b)
 	}
-	return make([]byte, 0), nil
+	if g.getter == nil {
+		return nil, ErrNotFound
+	}
+	if cg, ok := g.getter.(ContextGetter); ok {
+		return cg.GetContext(ctx, key)
+	}
+	return g.getter.Get(key)
 }
 
-func (g *mcache) Set(key string, value string) error {
+func (g *mcache) Set(key string, value string, tags ...string) error {
 	if key == "" {
 		return fmt.Errorf("key is required")
 	}
 
-	val := ByteView{b: []byte(value)}
-	g.baseCache.add(key, val)
-	return nil
+	g.negative.del(key)
+	return g.baseCache.add(key, g.encode([]byte(value)), tags...)
+}
+
+//按 tag 批量删除
+func (g *mcache) DelByTag(tag string) (int, error) {
+	if tag == "" {
+		return 0, fmt.Errorf("tag is required")
+	}
+	return g.baseCache.delByTag(tag), nil
+}
+
+//按前缀批量删除
+func (g *mcache) DelByPrefix(prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("prefix is required")
+	}
+	return g.baseCache.delByPrefix(prefix), nil
 }
 
 func (g *mcache) Del(key string) error {
@@ -74,3 +302,182 @@ func (g *mcache) Del(key string) error {
 	}
 	return nil
 }
+
+func (g *mcache) Incr(key string, delta int64) (int64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key is required")
+	}
+
+	g.negative.del(key)
+	return g.baseCache.incr(key, delta)
+}
+
+//获取数据及 CAS 版本号
+func (g *mcache) GetWithVersion(key string) ([]byte, uint64, error) {
+	if key == "" {
+		return make([]byte, 0), 0, fmt.Errorf("key is required")
+	}
+
+	if v, ver, ok := g.baseCache.getWithVersion(key); ok {
+		b, err := decode(v)
+		return b, ver, err
+	}
+	return make([]byte, 0), 0, nil
+}
+
+//版本号一致时写入 version 为 0 表示 key 必须不存在
+func (g *mcache) CompareAndSet(key string, value string, version uint64) (uint64, error) {
+	return g.setIf("cas", key, value, version)
+}
+
+//key 不存在时写入
+func (g *mcache) SetNX(key string, value string) (uint64, error) {
+	return g.setIf("nx", key, value, 0)
+}
+
+//key 存在时写入
+func (g *mcache) SetXX(key string, value string) (uint64, error) {
+	return g.setIf("xx", key, value, 0)
+}
+
+func (g *mcache) setIf(mode string, key string, value string, version uint64) (uint64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key is required")
+	}
+
+	ver, ok, err := g.baseCache.addIf(mode, key, g.encode([]byte(value)), version)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		switch mode {
+		case "nx":
+			return ver, ErrKeyExists
+		case "xx":
+			return ver, ErrKeyNotExists
+		}
+		return ver, ErrVersionMismatch
+	}
+	g.negative.del(key)
+	return ver, nil
+}
+
+//版本号一致时删除
+func (g *mcache) CompareAndDel(key string, version uint64) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	ok, err := g.baseCache.delIfVersion(key, version)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+//统计信息快照 gets/hits/misses 为 Get 接口的访问 其余为底层存储的统计
+type Stats struct {
+	Gets       int64 `json:"gets"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	Sets       int64 `json:"sets"`
+	Deletes    int64 `json:"deletes"`
+	Evictions  int64 `json:"evictions"`
+	UsedBytes  int64 `json:"used_bytes"`
+	MaxBytes   int64 `json:"max_bytes"`
+	Items      int64 `json:"items"`
+	Loads      int64 `json:"loads"`
+	LoadErrors int64 `json:"load_errors"`
+	LoadDedups int64 `json:"load_dedups"`
+	//压缩写入的条数及压缩前后的字节数 ratio 为压缩后/压缩前
+	Compressed       int64   `json:"compressed"`
+	CompressRawBytes int64   `json:"compress_raw_bytes"`
+	CompressOutBytes int64   `json:"compress_out_bytes"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	//超过 soft TTL 返回旧数据的次数 后台刷新次数 加载失败返回旧数据的次数
+	StaleHits    int64 `json:"stale_hits"`
+	Refreshes    int64 `json:"refreshes"`
+	StaleOnError int64 `json:"stale_on_error"`
+	//负缓存的条数及命中次数
+	NegativeItems int64 `json:"negative_items"`
+	NegativeHits  int64 `json:"negative_hits"`
+	//磁盘层的条数与字节数 spills 为内存淘汰后写入磁盘的条数 disk_hits 为从磁盘移回内存的条数
+	DiskItems  int64 `json:"disk_items"`
+	DiskBytes  int64 `json:"disk_bytes"`
+	DiskSpills int64 `json:"disk_spills"`
+	DiskHits   int64 `json:"disk_hits"`
+}
+
+func (g *mcache) Stats() Stats {
+	s := g.baseCache.stats()
+	d := g.baseCache.diskStats()
+	negItems, negHits := g.negative.stats()
+	return Stats{
+		Gets:       atomic.LoadInt64(&g.counters.gets),
+		Hits:       atomic.LoadInt64(&g.counters.hits),
+		Misses:     atomic.LoadInt64(&g.counters.misses),
+		Sets:       s.Sets,
+		Deletes:    s.Deletes,
+		Evictions:  s.Evictions,
+		UsedBytes:  s.UsedBytes,
+		MaxBytes:   s.MaxBytes,
+		Items:      s.Items,
+		Loads:      atomic.LoadInt64(&g.counters.loads),
+		LoadErrors: atomic.LoadInt64(&g.counters.loadErrors),
+		LoadDedups: atomic.LoadInt64(&g.counters.loadDedups),
+
+		Compressed:       atomic.LoadInt64(&g.compress.compressed),
+		CompressRawBytes: atomic.LoadInt64(&g.compress.rawBytes),
+		CompressOutBytes: atomic.LoadInt64(&g.compress.outBytes),
+		NegativeItems:    negItems,
+		NegativeHits:     negHits,
+		StaleHits:        atomic.LoadInt64(&g.counters.staleHits),
+		Refreshes:        atomic.LoadInt64(&g.counters.refreshes),
+		StaleOnError:     atomic.LoadInt64(&g.counters.staleOnErr),
+
+		DiskItems:  d.Items,
+		DiskBytes:  d.Bytes,
+		DiskSpills: d.Spills,
+		DiskHits:   d.Hits,
+	}.withRatio()
+}
+
+func (s Stats) withRatio() Stats {
+	s.CompressionRatio = 0
+	if s.CompressRawBytes > 0 {
+		s.CompressionRatio = float64(s.CompressOutBytes) / float64(s.CompressRawBytes)
+	}
+	return s
+}
+
+//累加 用于汇总多个节点
+func (s *Stats) add(o Stats) {
+	s.Gets += o.Gets
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Sets += o.Sets
+	s.Deletes += o.Deletes
+	s.Evictions += o.Evictions
+	s.UsedBytes += o.UsedBytes
+	s.MaxBytes += o.MaxBytes
+	s.Items += o.Items
+	s.Loads += o.Loads
+	s.LoadErrors += o.LoadErrors
+	s.LoadDedups += o.LoadDedups
+	s.Compressed += o.Compressed
+	s.CompressRawBytes += o.CompressRawBytes
+	s.CompressOutBytes += o.CompressOutBytes
+	s.StaleHits += o.StaleHits
+	s.Refreshes += o.Refreshes
+	s.StaleOnError += o.StaleOnError
+	s.NegativeItems += o.NegativeItems
+	s.NegativeHits += o.NegativeHits
+	s.DiskItems += o.DiskItems
+	s.DiskBytes += o.DiskBytes
+	s.DiskSpills += o.DiskSpills
+	s.DiskHits += o.DiskHits
+	*s = s.withRatio()
+}
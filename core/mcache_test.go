@@ -0,0 +1,166 @@
+package core
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+//测试用缓存 按存储引擎创建 调用方负责 Close
+func testCache(t *testing.T, engine string) *mcache {
+	return NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, Engine: engine}, nil)
+}
+
+//并发自增不丢失更新 两种存储引擎结果一致
+func TestIncrConcurrent(t *testing.T) {
+	for _, engine := range []string{engineLRU, engineArena} {
+		t.Run(engine, func(t *testing.T) {
+			g := testCache(t, engine)
+			defer g.Close()
+			const workers, n = 8, 500
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(delta int64) {
+					defer wg.Done()
+					for j := 0; j < n; j++ {
+						if _, err := g.Incr("counter", delta); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}(int64(1 + i%2*2)) //1 和 3 交替
+			}
+			wg.Wait()
+
+			want := int64(workers/2*n*1 + workers/2*n*3)
+			b, err := g.Get("counter")
+			if err != nil || string(b) != strconv.FormatInt(want, 10) {
+				t.Fatalf("Get(counter) = %q, %v; want %d", b, err, want)
+			}
+		})
+	}
+}
+
+func TestIncrErrors(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+	if n, err := g.Incr("new", -5); n != -5 || err != nil {
+		t.Fatalf("Incr(new, -5) = %d, %v; want -5, nil", n, err)
+	}
+
+	g.Set("str", "abc")
+	if _, err := g.Incr("str", 1); err == nil {
+		t.Fatal("Incr on non integer value: want error")
+	}
+
+	g.Set("max", strconv.FormatInt(math.MaxInt64, 10))
+	if _, err := g.Incr("max", 1); err == nil {
+		t.Fatal("Incr overflow: want error")
+	}
+	g.Set("min", strconv.FormatInt(math.MinInt64, 10))
+	if _, err := g.Incr("min", -1); err == nil {
+		t.Fatal("Incr underflow: want error")
+	}
+	//溢出时不修改原值
+	if b, _ := g.Get("max"); string(b) != strconv.FormatInt(math.MaxInt64, 10) {
+		t.Fatalf("Get(max) = %q after overflow; want unchanged", b)
+	}
+
+	g.HSet("h", "f", "v")
+	if _, err := g.Incr("h", 1); err != ErrWrongType {
+		t.Fatalf("Incr on hash = %v; want ErrWrongType", err)
+	}
+}
+
+//CAS/SetNX/SetXX/CompareAndDel 的版本号与存在性检查
+func TestConditionalWrites(t *testing.T) {
+	for _, engine := range []string{engineLRU, engineArena} {
+		t.Run(engine, func(t *testing.T) {
+			g := testCache(t, engine)
+			defer g.Close()
+
+			if _, err := g.SetXX("k", "v"); err != ErrKeyNotExists {
+				t.Fatalf("SetXX on missing key = %v; want ErrKeyNotExists", err)
+			}
+			if _, err := g.CompareAndSet("k", "v", 1); err != ErrVersionMismatch {
+				t.Fatalf("CAS on missing key = %v; want ErrVersionMismatch", err)
+			}
+			//key 不存在时返回不存在的错误 而不是版本不一致
+			if err := g.CompareAndDel("k", 1); err == nil || err == ErrVersionMismatch {
+				t.Fatalf("CompareAndDel on missing key = %v; want not exists error", err)
+			}
+
+			v1, err := g.SetNX("k", "a")
+			if err != nil || v1 == 0 {
+				t.Fatalf("SetNX = %d, %v; want version, nil", v1, err)
+			}
+			if _, err := g.SetNX("k", "b"); err != ErrKeyExists {
+				t.Fatalf("SetNX on existing key = %v; want ErrKeyExists", err)
+			}
+			//version 为 0 表示 key 必须不存在
+			if _, err := g.CompareAndSet("k", "b", 0); err != ErrVersionMismatch {
+				t.Fatalf("CAS with version 0 on existing key = %v; want ErrVersionMismatch", err)
+			}
+
+			v2, err := g.CompareAndSet("k", "b", v1)
+			if err != nil || v2 == v1 {
+				t.Fatalf("CAS = %d, %v; want new version, nil", v2, err)
+			}
+			if _, err := g.CompareAndSet("k", "c", v1); err != ErrVersionMismatch {
+				t.Fatalf("CAS with stale version = %v; want ErrVersionMismatch", err)
+			}
+			v3, err := g.SetXX("k", "c")
+			if err != nil || v3 == v2 {
+				t.Fatalf("SetXX = %d, %v; want new version, nil", v3, err)
+			}
+			b, ver, err := g.GetWithVersion("k")
+			if err != nil || string(b) != "c" || ver != v3 {
+				t.Fatalf("GetWithVersion = %q, %d, %v; want c, %d, nil", b, ver, err, v3)
+			}
+
+			if err := g.CompareAndDel("k", v2); err != ErrVersionMismatch {
+				t.Fatalf("CompareAndDel with stale version = %v; want ErrVersionMismatch", err)
+			}
+			if err := g.CompareAndDel("k", v3); err != nil {
+				t.Fatalf("CompareAndDel = %v; want nil", err)
+			}
+			if _, ver, _ := g.GetWithVersion("k"); ver != 0 {
+				t.Fatalf("version after CompareAndDel = %d; want 0", ver)
+			}
+			if _, err := g.CompareAndSet("k", "d", 0); err != nil {
+				t.Fatalf("CAS with version 0 on missing key = %v; want nil", err)
+			}
+		})
+	}
+}
+
+//并发 CAS 同一个版本号只有一个成功
+func TestCompareAndSetConcurrent(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+	ver, _ := g.SetNX("k", "0")
+
+	const n = 16
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := g.CompareAndSet("k", strconv.Itoa(i), ver); err == nil {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			} else if err != ErrVersionMismatch {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Fatalf("%d CAS succeeded with the same version; want 1", won)
+	}
+}
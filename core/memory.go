@@ -0,0 +1,164 @@
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ylt94/mycache/logger"
+)
+
+//节点级内存配置 对节点上所有缓存生效
+type MemoryConfig struct {
+	Limit         int64         `json:"limit" usage:"节点上所有缓存共用的内存上限 超过时从占用最多的缓存淘汰 0 不限制"`
+	HeapLimit     int64         `json:"heap_limit" usage:"进程堆内存上限 超过时主动淘汰数据 0 不启用"`
+	CheckInterval time.Duration `json:"check_interval" usage:"检查堆内存的间隔"`
+}
+
+var DefaultMemoryConfig = MemoryConfig{
+	CheckInterval: time.Second,
+}
+
+func (c MemoryConfig) validate() error {
+	if c.Limit < 0 || c.HeapLimit < 0 {
+		return fmt.Errorf("memory.limit and memory.heap_limit must not be negative")
+	}
+	if c.HeapLimit > 0 && c.CheckInterval <= 0 {
+		return fmt.Errorf("memory.check_interval must be positive")
+	}
+	return nil
+}
+
+//堆内存超限时每次至少淘汰已用内存的比例 避免每次只淘汰很少的数据
+const heapEvictFraction = 0.05
+
+type memoryManager struct {
+	mu     sync.Mutex
+	limit  int64 //原子读写
+	caches []*cache
+	stop   chan struct{}
+	//堆内存超限触发的淘汰次数
+	heapEvictions int64
+}
+
+var nodeMemory = &memoryManager{}
+
+//设置节点内存限制 HeapLimit 大于 0 时启动堆内存检查
+func SetMemoryConfig(c MemoryConfig) {
+	nodeMemory.mu.Lock()
+	defer nodeMemory.mu.Unlock()
+	atomic.StoreInt64(&nodeMemory.limit, c.Limit)
+	if nodeMemory.stop != nil {
+		close(nodeMemory.stop)
+		nodeMemory.stop = nil
+	}
+	if c.HeapLimit > 0 {
+		nodeMemory.stop = make(chan struct{})
+		go nodeMemory.watchHeap(c.HeapLimit, c.CheckInterval, nodeMemory.stop)
+	}
+}
+
+func (m *memoryManager) register(c *cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caches = append(m.caches, c)
+}
+
+func (m *memoryManager) unregister(c *cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, cur := range m.caches {
+		if cur == c {
+			m.caches = append(m.caches[:i], m.caches[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *memoryManager) snapshot() []*cache {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*cache(nil), m.caches...)
+}
+
+//所有缓存已用内存之和
+func (m *memoryManager) used(caches []*cache) int64 {
+	var n int64
+	for _, c := range caches {
+		n += c.usedBytes()
+	}
+	return n
+}
+
+//超过节点内存上限时淘汰 写入后调用 调用时不能持有缓存的锁
+func (m *memoryManager) reclaim() {
+	limit := atomic.LoadInt64(&m.limit)
+	if limit <= 0 {
+		return
+	}
+	caches := m.snapshot()
+	if over := m.used(caches) - limit; over > 0 {
+		m.evict(caches, over)
+	}
+}
+
+//从占用最多的缓存开始淘汰 直到释放 n 字节或没有数据
+func (m *memoryManager) evict(caches []*cache, n int64) int64 {
+	var freed int64
+	for freed < n {
+		var largest *cache
+		var most, second int64
+		for _, c := range caches {
+			if used := c.usedBytes(); used > most {
+				largest, most, second = c, used, most
+			} else if used > second {
+				second = used
+			}
+		}
+		if largest == nil {
+			break
+		}
+		//每次最多淘汰到与第二大的缓存持平 使各缓存均衡
+		chunk := n - freed
+		if d := most - second; d > 0 && d < chunk {
+			chunk = d
+		}
+		f := largest.evict(chunk)
+		if f == 0 {
+			break
+		}
+		freed += f
+	}
+	return freed
+}
+
+//定期读取堆内存 超过上限时淘汰 下次 GC 后生效
+func (m *memoryManager) watchHeap(limit int64, interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var ms runtime.MemStats
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		runtime.ReadMemStats(&ms)
+		over := int64(ms.HeapAlloc) - limit
+		if over <= 0 {
+			continue
+		}
+		caches := m.snapshot()
+		if floor := int64(float64(m.used(caches)) * heapEvictFraction); over < floor {
+			over = floor
+		}
+		freed := m.evict(caches, over)
+		if freed == 0 {
+			continue
+		}
+		atomic.AddInt64(&m.heapEvictions, 1)
+		logger.Warn("heap over limit, evicted", logger.F("heap_alloc", ms.HeapAlloc), logger.F("limit", limit), logger.F("freed", freed))
+	}
+}
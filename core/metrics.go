@@ -0,0 +1,130 @@
+package core
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ylt94/mycache/metrics"
+)
+
+const metricsPath = "/metrics"
+
+//节点指标
+type nodeMetrics struct {
+	registry *metrics.Registry
+	latency  *metrics.HistogramVec
+}
+
+func newNodeMetrics(g *mcache) *nodeMetrics {
+	r := metrics.NewRegistry()
+	//每次采集只读取一次统计快照
+	var mu sync.Mutex
+	var snapshot Stats
+	r.OnCollect(func() {
+		s := g.Stats()
+		mu.Lock()
+		snapshot = s
+		mu.Unlock()
+	})
+	stat := func(fn func(s Stats) int64) func() float64 {
+		return func() float64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return float64(fn(snapshot))
+		}
+	}
+	r.CounterFunc("mycache_gets_total", "Number of cache gets.", stat(func(s Stats) int64 { return s.Gets }))
+	r.CounterFunc("mycache_hits_total", "Number of cache hits.", stat(func(s Stats) int64 { return s.Hits }))
+	r.CounterFunc("mycache_misses_total", "Number of cache misses.", stat(func(s Stats) int64 { return s.Misses }))
+	r.CounterFunc("mycache_sets_total", "Number of entries written.", stat(func(s Stats) int64 { return s.Sets }))
+	r.CounterFunc("mycache_deletes_total", "Number of entries deleted.", stat(func(s Stats) int64 { return s.Deletes }))
+	r.CounterFunc("mycache_loads_total", "Number of getter calls.", stat(func(s Stats) int64 { return s.Loads }))
+	r.CounterFunc("mycache_load_errors_total", "Number of failed getter calls.", stat(func(s Stats) int64 { return s.LoadErrors }))
+	r.CounterFunc("mycache_load_dedups_total", "Number of loads deduplicated by singleflight.", stat(func(s Stats) int64 { return s.LoadDedups }))
+	r.CounterFunc("mycache_evictions_total", "Number of entries evicted for memory.", stat(func(s Stats) int64 { return s.Evictions }))
+	r.GaugeFunc("mycache_used_bytes", "Bytes used by cached entries.", stat(func(s Stats) int64 { return s.UsedBytes }))
+	r.GaugeFunc("mycache_max_bytes", "Maximum bytes of cached entries, 0 means unlimited.", stat(func(s Stats) int64 { return s.MaxBytes }))
+	r.GaugeFunc("mycache_items", "Number of cached entries.", stat(func(s Stats) int64 { return s.Items }))
+	r.CounterFunc("mycache_compressed_total", "Number of values stored compressed.", stat(func(s Stats) int64 { return s.Compressed }))
+	r.CounterFunc("mycache_compress_raw_bytes_total", "Bytes of values before compression.", stat(func(s Stats) int64 { return s.CompressRawBytes }))
+	r.CounterFunc("mycache_compress_out_bytes_total", "Bytes of values after compression.", stat(func(s Stats) int64 { return s.CompressOutBytes }))
+	r.CounterFunc("mycache_stale_hits_total", "Number of gets served a value past its soft TTL.", stat(func(s Stats) int64 { return s.StaleHits }))
+	r.CounterFunc("mycache_refreshes_total", "Number of background refreshes started.", stat(func(s Stats) int64 { return s.Refreshes }))
+	r.CounterFunc("mycache_stale_on_error_total", "Number of gets served a stale value because the getter failed.", stat(func(s Stats) int64 { return s.StaleOnError }))
+	r.GaugeFunc("mycache_negative_items", "Number of cached loader misses and errors.", stat(func(s Stats) int64 { return s.NegativeItems }))
+	r.CounterFunc("mycache_negative_hits_total", "Number of gets answered from the negative cache.", stat(func(s Stats) int64 { return s.NegativeHits }))
+	r.GaugeFunc("mycache_disk_items", "Number of entries in the disk tier.", stat(func(s Stats) int64 { return s.DiskItems }))
+	r.GaugeFunc("mycache_disk_bytes", "Bytes used by entries in the disk tier.", stat(func(s Stats) int64 { return s.DiskBytes }))
+	r.CounterFunc("mycache_disk_spills_total", "Number of entries evicted from memory into the disk tier.", stat(func(s Stats) int64 { return s.DiskSpills }))
+	r.CounterFunc("mycache_disk_hits_total", "Number of entries promoted from the disk tier back to memory.", stat(func(s Stats) int64 { return s.DiskHits }))
+	r.CounterFunc("mycache_heap_pressure_evictions_total", "Number of times entries were evicted because the heap exceeded its limit.", func() float64 {
+		return float64(atomic.LoadInt64(&nodeMemory.heapEvictions))
+	})
+	return &nodeMetrics{
+		registry: r,
+		latency:  r.HistogramVec("mycache_request_duration_seconds", "Node request latency by action.", "action", nil),
+	}
+}
+
+//master 指标
+type masterMetrics struct {
+	registry          *metrics.Registry
+	heartBeatFailures *metrics.Counter
+	breakerRejections *metrics.Counter
+	rateLimited       *metrics.CounterVec
+	latency           *metrics.HistogramVec
+}
+
+func newMasterMetrics(m *master) *masterMetrics {
+	r := metrics.NewRegistry()
+	r.GaugeFunc("mycache_master_nodes", "Number of registered nodes.", func() float64 {
+		return float64(len(m.nodeNames()))
+	})
+	r.GaugeFunc("mycache_master_open_breakers", "Number of nodes whose circuit breaker is not closed.", func() float64 {
+		n := 0
+		for _, name := range m.nodeNames() {
+			if g, ok := m.getNodeByName(name); ok && g.breaker.currentState() != breakerClosed {
+				n++
+			}
+		}
+		return float64(n)
+	})
+	r.GaugeFunc("mycache_master_ring_size", "Number of points on the consistent hash ring.", func() float64 {
+		return float64(m.hash.Len())
+	})
+	return &masterMetrics{
+		registry:          r,
+		heartBeatFailures: r.Counter("mycache_master_heartbeat_failures_total", "Number of failed node heartbeats."),
+		breakerRejections: r.Counter("mycache_proxy_breaker_rejections_total", "Number of requests rejected by an open node circuit breaker."),
+		rateLimited:       r.CounterVec("mycache_proxy_rate_limited_total", "Number of requests rejected by rate limiting by reason.", "reason"),
+		latency:           r.HistogramVec("mycache_proxy_request_duration_seconds", "Service proxy request latency by action.", "action", nil),
+	}
+}
+
+//记录耗时 action 只保留已知命令 避免标签过多
+func observeSince(h *metrics.HistogramVec, action string, start time.Time) {
+	if !knownActions[action] {
+		action = "unknown"
+	}
+	h.With(action).Observe(time.Since(start).Seconds())
+}
+
+var knownActions = map[string]bool{
+	"ping": true, "get": true, "set": true, "del": true,
+	"incr": true, "decr": true, "incrby": true,
+	"gets": true, "cas": true, "cad": true, "setnx": true, "setxx": true,
+	"scan": true, "deltag": true, "delprefix": true, "stats": true,
+}
+
+func init() {
+	for act := range typedActions {
+		knownActions[act] = true
+	}
+}
+
+func serveMetrics(w http.ResponseWriter, r *metrics.Registry) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WritePrometheus(w)
+}
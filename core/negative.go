@@ -0,0 +1,109 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ylt94/mycache/lru"
+)
+
+//命中负缓存时返回 Err 为 getter 当时返回的错误
+//可以用 errors.Is(err, ErrNotFound) 判断 key 不存在
+type NegativeError struct {
+	Err error
+}
+
+func (e *NegativeError) Error() string {
+	return "negative cached:" + e.Err.Error()
+}
+
+func (e *NegativeError) Unwrap() error {
+	return e.Err
+}
+
+type negativeEntry struct {
+	err     error
+	expires time.Time
+}
+
+func (e *negativeEntry) Len() int {
+	return len(e.err.Error())
+}
+
+//负缓存 保存 getter 返回的不存在标记及错误 与正常数据分开存放 不计入缓存内存
+type negativeCache struct {
+	mu          sync.Mutex
+	lru         *lru.Cache
+	notFoundTTL time.Duration
+	errorTTL    time.Duration
+	maxEntries  int
+	hits        int64
+}
+
+func newNegativeCache(cfg CacheConfig) *negativeCache {
+	return &negativeCache{
+		lru:         lru.New(0, nil),
+		notFoundTTL: cfg.NegativeTTL,
+		errorTTL:    cfg.ErrorTTL,
+		maxEntries:  cfg.NegativeMaxEntries,
+	}
+}
+
+//记录 getter 返回的错误 按错误类型选择有效期 有效期为 0 时不记录
+func (n *negativeCache) add(key string, err error) {
+	ttl := n.errorTTL
+	if errors.Is(err, ErrNotFound) {
+		ttl = n.notFoundTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.lru.Add(key, &negativeEntry{err: err, expires: time.Now().Add(ttl)})
+	for n.maxEntries > 0 && n.lru.Len() > n.maxEntries {
+		n.lru.RemoveOldest()
+	}
+}
+
+//返回未过期的负缓存 过期的直接删除
+func (n *negativeCache) get(key string) (*NegativeError, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	v, ok := n.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	e := v.(*negativeEntry)
+	if time.Now().After(e.expires) {
+		n.lru.Del(key)
+		return nil, false
+	}
+	n.hits++
+	return &NegativeError{Err: e.err}, true
+}
+
+//是否有未过期的负缓存 不计入命中
+func (n *negativeCache) has(key string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	v, ok := n.lru.Peek(key)
+	return ok && time.Now().Before(v.(*negativeEntry).expires)
+}
+
+//key 被写入后负缓存失效
+func (n *negativeCache) del(key string) {
+	if n.notFoundTTL <= 0 && n.errorTTL <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.lru.Del(key)
+}
+
+func (n *negativeCache) stats() (items int64, hits int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return int64(n.lru.Len()), n.hits
+}
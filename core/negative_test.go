@@ -0,0 +1,160 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errBackend = errors.New("backend down")
+
+//按 key 返回结果的 getter 记录每个 key 的调用次数
+type countingGetter struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newCountingGetter() *countingGetter {
+	return &countingGetter{calls: make(map[string]int)}
+}
+
+func (g *countingGetter) Get(key string) ([]byte, error) {
+	g.mu.Lock()
+	g.calls[key]++
+	g.mu.Unlock()
+	switch {
+	case strings.HasPrefix(key, "missing"):
+		return nil, ErrNotFound
+	case strings.HasPrefix(key, "broken"):
+		return nil, errBackend
+	}
+	return []byte("value:" + key), nil
+}
+
+func (g *countingGetter) count(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls[key]
+}
+
+//通过节点 get 命令读取
+func httpGet(t *testing.T, base string, key string) (int, string, bool) {
+	t.Helper()
+	res, err := http.Get(base + defaultBasePath + "?action=get&key=" + url.QueryEscape(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(body), res.Header.Get(negativeHeader) != ""
+}
+
+//不存在的 key 返回 404 有效期内不再调用 getter 并带上负缓存标记
+func TestNegativeCacheNotFound(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Cache.NegativeTTL = 50 * time.Millisecond
+	getter := newCountingGetter()
+	srv, cache := newTestNode(t, cfg, getter)
+	defer srv.Close()
+	defer cache.Close()
+
+	if code, _, neg := httpGet(t, srv.URL, "missing"); code != http.StatusNotFound || neg {
+		t.Fatalf("first get = %d, negative %v; want 404 without marker", code, neg)
+	}
+	for i := 0; i < 3; i++ {
+		if code, _, neg := httpGet(t, srv.URL, "missing"); code != http.StatusNotFound || !neg {
+			t.Fatalf("cached get = %d, negative %v; want 404 with marker", code, neg)
+		}
+	}
+	if n := getter.count("missing"); n != 1 {
+		t.Fatalf("getter called %d times within the TTL; want 1", n)
+	}
+	if s := cache.Stats(); s.NegativeItems != 1 || s.NegativeHits != 3 {
+		t.Fatalf("negative items %d hits %d; want 1, 3", s.NegativeItems, s.NegativeHits)
+	}
+
+	//过期后重新调用 getter
+	time.Sleep(60 * time.Millisecond)
+	if code, _, neg := httpGet(t, srv.URL, "missing"); code != http.StatusNotFound || neg {
+		t.Fatalf("get after TTL = %d, negative %v; want 404 without marker", code, neg)
+	}
+	if n := getter.count("missing"); n != 2 {
+		t.Fatalf("getter called %d times after the TTL; want 2", n)
+	}
+
+	//写入后负缓存失效
+	cache.Set("missing", "now exists")
+	if code, body, neg := httpGet(t, srv.URL, "missing"); code != http.StatusOK || body != "now exists" || neg {
+		t.Fatalf("get after set = %d %q, negative %v; want 200 now exists", code, body, neg)
+	}
+
+	//存在的 key 正常返回
+	if code, body, _ := httpGet(t, srv.URL, "k"); code != http.StatusOK || body != "value:k" {
+		t.Fatalf("get existing = %d %q; want 200 value:k", code, body)
+	}
+}
+
+//ErrorTTL 为 0 时错误不缓存 设置后按 ErrorTTL 缓存 可以取到原始错误
+func TestNegativeCacheErrors(t *testing.T) {
+	getter := newCountingGetter()
+	g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, NegativeTTL: time.Minute}, getter)
+	defer g.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get("broken"); err != errBackend {
+			t.Fatalf("Get(broken) = %v; want %v", err, errBackend)
+		}
+	}
+	if n := getter.count("broken"); n != 2 {
+		t.Fatalf("getter called %d times without ErrorTTL; want 2", n)
+	}
+
+	g2 := NewMCache(t.Name()+"2", CacheConfig{MaxBytes: 1 << 20, ErrorTTL: time.Minute}, getter)
+	defer g2.Close()
+	g2.Get("broken2")
+	_, err := g2.Get("broken2")
+	var neg *NegativeError
+	if !errors.As(err, &neg) || !errors.Is(err, errBackend) {
+		t.Fatalf("cached error = %v; want NegativeError wrapping %v", err, errBackend)
+	}
+	if n := getter.count("broken2"); n != 1 {
+		t.Fatalf("getter called %d times with ErrorTTL; want 1", n)
+	}
+	//NegativeTTL 为 0 时不缓存不存在
+	g2.Get("missing2")
+	g2.Get("missing2")
+	if n := getter.count("missing2"); n != 2 {
+		t.Fatalf("getter called %d times without NegativeTTL; want 2", n)
+	}
+}
+
+//超过 NegativeMaxEntries 时淘汰最早的记录
+func TestNegativeMaxEntries(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Cache.NegativeTTL = time.Minute
+	cfg.Cache.NegativeMaxEntries = 2
+	getter := newCountingGetter()
+	srv, cache := newTestNode(t, cfg, getter)
+	defer srv.Close()
+	defer cache.Close()
+
+	for _, key := range []string{"missing1", "missing2", "missing3"} {
+		httpGet(t, srv.URL, key)
+	}
+	if s := cache.Stats(); s.NegativeItems != 2 {
+		t.Fatalf("negative items = %d; want 2", s.NegativeItems)
+	}
+	if _, _, neg := httpGet(t, srv.URL, "missing3"); !neg {
+		t.Fatal("newest entry evicted")
+	}
+	if _, _, neg := httpGet(t, srv.URL, "missing1"); neg {
+		t.Fatal("oldest entry kept beyond NegativeMaxEntries")
+	}
+	if n := getter.count("missing1"); n != 2 {
+		t.Fatalf("getter called %d times for the evicted key; want 2", n)
+	}
+}
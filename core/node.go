@@ -1,81 +1,166 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"google.golang.org/protobuf/proto"
-
+	"github.com/ylt94/mycache/consistenthash"
+	"github.com/ylt94/mycache/logger"
 	mproto "github.com/ylt94/mycache/proto"
+	"github.com/ylt94/mycache/trace"
 )
 
 const defaultBasePath = "/node/"
 
-//const defaultReplicas = 50
+const versionHeader = "X-Cache-Version"
+
+const defaultReplicas = 1
+
+//节点间转发的请求带上该请求头 避免再次转发
+const forwardedHeader = "X-Mycache-Forwarded"
+
+//发给备用节点的读请求带上该请求头 备用节点没有副本 只读本地数据 加载的数据不写入缓存
+const fallbackHeader = "X-Mycache-Fallback"
+
+//get 命中负缓存时带上该响应头
+const negativeHeader = "X-Mycache-Negative"
 
 type NodeServer struct {
-	self     string
-	basePath string
-	//mu          sync.Mutex
-	//peers       *consistenthash.Map
+	self        string
+	basePath    string
+	mu          sync.RWMutex
+	peers       *consistenthash.Map //无 master 模式下的一致性hash 为空表示由 master 路由
 	NodeGetters map[string]*NodeGetter
 	mainCache   *mcache
+	metrics     *nodeMetrics
+	cfg         Config
+	auth        *authenticator
+	client      *http.Client //访问 master 及其他节点
 }
 
 type NodeGetter struct {
-	baseURL string
+	baseURL  string
+	basePath string
+	opts     ClientOptions
+	client   *http.Client
+	breaker  *breaker
 }
 
-func NewNodeServer(self string, cache *mcache) *NodeServer {
+func NewNodeServer(self string, cache *mcache, cfg Config) *NodeServer {
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		panic(err.Error())
+	}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		panic(err.Error())
+	}
 	return &NodeServer{
-		self:      self,            //自己的ip地址端口信息
-		basePath:  defaultBasePath, //通讯地址前缀
+		self:      self,         //自己的ip地址端口信息
+		basePath:  cfg.BasePath, //通讯地址前缀
 		mainCache: cache,
+		metrics:   newNodeMetrics(cache),
+		cfg:       cfg,
+		auth:      auth,
+		client:    client,
 	}
 }
 
 func (h *NodeServer) Log(format string, v ...interface{}) {
-	log.Printf("[Server %s] %s", h.self, fmt.Sprintf(format, v...))
+	logger.Info(fmt.Sprintf(format, v...), logger.F("node", h.self))
 }
 
 func (h *NodeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == metricsPath {
+		serveMetrics(w, h.metrics.registry)
+		return
+	}
+
+	ctx, span := trace.Start(trace.Extract(r.Context(), trace.HeaderCarrier(r.Header)), "NodeServer.ServeHTTP")
+	defer span.End()
+	r = withRequestID(w, r.WithContext(ctx))
 	values := r.URL.Query()
 	action := values.Get("action")
+	span.SetAttr("action", action)
+	start := time.Now()
+	defer accessLog(r, "node", start)
+	defer observeSince(h.metrics.latency, strings.ToLower(action), start)
 	key := values.Get("key")
 	val := values.Get("value")
 	if action == "" {
 		w.Write([]byte("action is required"))
 		return
 	}
+	if !h.auth.allow(w, r, action, authScope(r)) {
+		return
+	}
 	if action == "ping" {
 		w.Write([]byte("pong"))
 		return
 	}
+	if strings.ToLower(action) == "scan" {
+		h.serveScan(w, values)
+		return
+	}
+	if act := strings.ToLower(action); act == "deltag" || act == "delprefix" {
+		h.serveInvalidate(w, act, values)
+		return
+	}
+	if strings.ToLower(action) == "stats" {
+		h.serveStats(w)
+		return
+	}
 
 	if key == "" {
 		w.Write([]byte("key is required"))
 		return
 	}
 
+	//无 master 模式下转发给 key 所属的节点
+	if r.Header.Get(forwardedHeader) == "" {
+		if peer, ok := h.pickPeer(key); ok {
+			if err := peer.HandleByHTTP(w, r); err != nil {
+				span.SetError(err)
+				http.Error(w, "peer error:"+err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
+	}
+
 	var err error
 	//TODO 反射
 	if strings.ToLower(action) == "set" { //set 命令
-		err = h.mainCache.Set(key, val)
+		err = h.mainCache.Set(key, val, values["tag"]...)
 		if err != nil {
 			w.Write([]byte("cache set error:" + err.Error()))
 			return
 		}
-	} else if strings.ToLower(action) == "get" { //get 命令
-		body, err := h.mainCache.Get(key)
+	} else if strings.ToLower(action) == "get" && r.Header.Get(fallbackHeader) != "" { //作为备用节点的 get
+		body, err := h.mainCache.getNoStore(r.Context(), key)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "fallback miss", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			w.Write([]byte("cache get error:" + err.Error()))
 			return
 		}
+		w.Write(body)
+	} else if strings.ToLower(action) == "get" { //get 命令
+		body, err := h.mainCache.GetContext(r.Context(), key)
+		if err != nil {
+			span.SetError(err)
+			writeGetError(w, err)
+			return
+		}
 		//proto 编码
 		//body, err := proto.Marshal(&mproto.Response{Value: view.ByteSlice()})
 		//if err != nil {
@@ -91,12 +176,141 @@ func (h *NodeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("cache del error:" + err.Error()))
 			return
 		}
+	} else if act := strings.ToLower(action); act == "incr" || act == "decr" || act == "incrby" { //incr/decr/incrby 命令
+		delta := int64(1)
+		if act == "incrby" {
+			delta, err = strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				w.Write([]byte("cache incrby error:value is not an integer"))
+				return
+			}
+		} else if act == "decr" {
+			delta = -1
+		}
+		n, err := h.mainCache.Incr(key, delta)
+		if err != nil {
+			w.Write([]byte("cache " + act + " error:" + err.Error()))
+			return
+		}
+		w.Write([]byte(strconv.FormatInt(n, 10)))
+	} else if act := strings.ToLower(action); act == "gets" || act == "cas" || act == "cad" || act == "setnx" || act == "setxx" { //带版本号的命令
+		h.serveVersioned(w, act, key, val, values.Get("version"))
+	} else if act := strings.ToLower(action); typedActions[act] { //hash/list/set 命令
+		h.serveTyped(w, act, key, values)
+	}
+}
+
+var typedActions = map[string]bool{
+	"hset": true, "hget": true, "hdel": true, "hgetall": true,
+	"lpush": true, "rpush": true, "lpop": true, "rpop": true, "lrange": true,
+	"sadd": true, "srem": true, "smembers": true, "sismember": true,
+}
+
+//多个成员使用重复的 value 参数传递 集合类结果以 json 返回
+func (h *NodeServer) serveTyped(w http.ResponseWriter, act string, key string, values url.Values) {
+	val := values.Get("value")
+	field := values.Get("field")
+	var res interface{}
+	var err error
+	switch act {
+	case "hset":
+		res, err = h.mainCache.HSet(key, field, val)
+	case "hget":
+		var v string
+		v, _, err = h.mainCache.HGet(key, field)
+		res = v
+	case "hdel":
+		res, err = h.mainCache.HDel(key, field)
+	case "hgetall":
+		res, err = h.mainCache.HGetAll(key)
+	case "lpush", "rpush":
+		res, err = h.mainCache.Push(key, act == "lpush", values["value"]...)
+	case "lpop", "rpop":
+		var v string
+		v, _, err = h.mainCache.Pop(key, act == "lpop")
+		res = v
+	case "lrange":
+		start, stop := 0, -1
+		if s := values.Get("start"); s != "" {
+			start, err = strconv.Atoi(s)
+		}
+		if s := values.Get("stop"); s != "" && err == nil {
+			stop, err = strconv.Atoi(s)
+		}
+		if err != nil {
+			w.Write([]byte("cache lrange error:start and stop must be integers"))
+			return
+		}
+		res, err = h.mainCache.Range(key, start, stop)
+	case "sadd":
+		res, err = h.mainCache.SAdd(key, values["value"]...)
+	case "srem":
+		res, err = h.mainCache.SRem(key, values["value"]...)
+	case "smembers":
+		res, err = h.mainCache.SMembers(key)
+	case "sismember":
+		res, err = h.mainCache.SIsMember(key, val)
+	}
+	if err != nil {
+		w.Write([]byte("cache " + act + " error:" + err.Error()))
+		return
+	}
+
+	switch v := res.(type) {
+	case string:
+		w.Write([]byte(v))
+	case int:
+		w.Write([]byte(strconv.Itoa(v)))
+	case bool:
+		w.Write([]byte(strconv.FormatBool(v)))
+	default:
+		writeJSON(w, v)
+	}
+}
+
+//版本号通过 X-Cache-Version 响应头返回
+func (h *NodeServer) serveVersioned(w http.ResponseWriter, act string, key string, val string, version string) {
+	var ver uint64
+	var err error
+	if act == "cas" || act == "cad" {
+		ver, err = strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			w.Write([]byte("cache " + act + " error:version is required"))
+			return
+		}
+	}
+
+	switch act {
+	case "gets":
+		body, ver, err := h.mainCache.GetWithVersion(key)
+		if err != nil {
+			w.Write([]byte("cache gets error:" + err.Error()))
+			return
+		}
+		w.Header().Set(versionHeader, strconv.FormatUint(ver, 10))
+		w.Write(body)
+		return
+	case "cad":
+		err = h.mainCache.CompareAndDel(key, ver)
+	case "cas":
+		ver, err = h.mainCache.CompareAndSet(key, val, ver)
+	case "setnx":
+		ver, err = h.mainCache.SetNX(key, val)
+	case "setxx":
+		ver, err = h.mainCache.SetXX(key, val)
+	}
+	if act != "cad" {
+		w.Header().Set(versionHeader, strconv.FormatUint(ver, 10))
+	}
+	if err != nil {
+		w.Write([]byte("cache " + act + " error:" + err.Error()))
+		return
 	}
 }
 
 func (h *NodeServer) register(masterAddr string) {
-	url := masterAddr + "/mycache?action=register&name=" + h.self
-	resp, err := http.Get(url)
+	u := masterAddr + "/mycache?action=register&name=" + url.QueryEscape(h.self)
+	resp, err := h.client.Get(u)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -113,83 +327,109 @@ func (h *NodeServer) register(masterAddr string) {
 	if string(bytes) != "success" {
 		panic("node register returned:" + string(bytes))
 	}
-	log.Println("node:" + h.self + " register success")
+	h.Log("node register success")
 }
 
 //从节点获取value proto
 func (g *NodeGetter) Handle(in *mproto.Request, out *mproto.Response) error {
-	u := fmt.Sprintf("%v%v", g.baseURL, url.QueryEscape(in.GetKey()))
-	log.Println("start get data from", u)
-	res, err := http.Get(u)
+	q := url.Values{}
+	q.Set("action", "get")
+	q.Set("key", in.GetKey())
+	body, err := g.do(context.Background(), q)
 	if err != nil {
 		return err
 	}
+	//节点 get 返回原始数据
+	out.Value = body
+	return nil
+}
 
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned:%v", res.Status)
+//向节点发送命令 返回响应内容
+func (g *NodeGetter) do(ctx context.Context, q url.Values) ([]byte, error) {
+	header := http.Header{}
+	if id := logger.RequestID(ctx); id != "" {
+		header.Set(requestIDHeader, id)
 	}
-
-	bytes, err := ioutil.ReadAll(res.Body)
+	trace.Inject(ctx, trace.HeaderCarrier(header))
+	res, err := g.fetch(ctx, g.baseURL+g.basePath+"?"+q.Encode(), header, q.Get("action"))
 	if err != nil {
-		return fmt.Errorf("reading response  body: %v", err)
+		return nil, err
 	}
-
-	err = proto.Unmarshal(bytes, out)
-	if err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
-	}
-
-	return nil
+	return res.body, nil
 }
 
 func (g *NodeGetter) HandleByHTTP(w http.ResponseWriter, r *http.Request) error {
-	u := g.baseURL + r.URL.String()
-	log.Println("start get data from", u)
-	res, err := http.Get(u)
-	if err != nil {
+	res, err := g.forward(r, false)
+	if res == nil {
 		return err
 	}
+	res.writeTo(w)
+	return nil
+}
 
-	defer res.Body.Close()
+//转发请求 节点返回 4xx 时同时返回响应与错误
+func (g *NodeGetter) forward(r *http.Request, fallback bool) (res *nodeResponse, err error) {
+	ctx, span := trace.Start(r.Context(), "NodeGetter.HandleByHTTP")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	u := g.baseURL + g.basePath + "?" + r.URL.RawQuery
+	span.SetAttr("url", u)
+	logger.FromContext(ctx).Debug("start get data", logger.F("url", u))
+	header := http.Header{}
+	header.Set(requestIDHeader, logger.RequestID(ctx))
+	header.Set(forwardedHeader, "1")
+	if fallback {
+		header.Set(fallbackHeader, "1")
+	}
+	trace.Inject(ctx, trace.HeaderCarrier(header))
+	return g.fetch(ctx, u, header, r.URL.Query().Get("action"))
+}
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned:%v", res.Status)
+//key 不存在时返回 404 命中负缓存时带上 negativeHeader 其他错误与其他命令一致
+func writeGetError(w http.ResponseWriter, err error) {
+	var neg *NegativeError
+	if errors.As(err, &neg) {
+		w.Header().Set(negativeHeader, "1")
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "cache get error:"+err.Error(), http.StatusNotFound)
+		return
 	}
+	w.Write([]byte("cache get error:" + err.Error()))
+}
 
-	bytes, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("reading response  body: %v", err)
+//把节点响应写给客户端
+func (res *nodeResponse) writeTo(w http.ResponseWriter) {
+	for _, h := range []string{versionHeader, negativeHeader} {
+		if v := res.header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
 	}
-	w.Write(bytes)
-	return nil
+	if res.status != http.StatusOK {
+		w.Header().Set("Content-Type", res.header.Get("Content-Type"))
+		w.WriteHeader(res.status)
+	}
+	w.Write(res.body)
 }
 
 //心跳检测
 func (g *NodeGetter) heartBeat(timeOut time.Duration) error {
-	u := g.baseURL + "/?action=ping"
-	log.Println("start heart beat to", u)
+	u := g.baseURL + g.basePath + "?action=ping"
+	logger.Debug("start heart beat", logger.F("url", u))
 
-	client := http.Client{Timeout: timeOut}
-	res, err := client.Get(u)
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	//心跳不重试 由 master 判断失败次数
+	res, _, err := g.fetchOnce(ctx, u, nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("heart beat return error: %v", err)
 	}
 
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("heart beat return error: %v", res.Status)
-	}
-
-	bytes, err := ioutil.ReadAll(res.Body)
-	log.Println("node heart beat returned:", string(bytes))
-	if err != nil {
-		return fmt.Errorf("heart beat reading reponse body error:%v", err)
-	}
-	if string(bytes) != "pong" {
-		return fmt.Errorf("heart beat reading reponse body content error:%v", err)
+	logger.Debug("node heart beat returned", logger.F("url", u), logger.F("body", string(res.body)))
+	if string(res.body) != "pong" {
+		return fmt.Errorf("heart beat reading reponse body content error:%s", string(res.body))
 	}
 	return nil
 }
@@ -198,8 +438,19 @@ func ServerStart(srv *NodeServer, mAddr string) {
 	//去master注册
 	srv.register(mAddr)
 	//请求处理
-	http.Handle("/", srv)
-	http.ListenAndServe(srv.self[7:], nil)
+	mx := http.NewServeMux()
+	srv.handle(mx)
+	if err := listenAndServe(srv.self, mx, srv.cfg.TLS, true); err != nil {
+		panic(err.Error())
+	}
+}
+
+//挂载到路径前缀 前缀不是 / 时 metrics 单独挂载
+func (h *NodeServer) handle(mx *http.ServeMux) {
+	mx.Handle(h.basePath, h)
+	if h.basePath != "/" {
+		mx.Handle(metricsPath, h)
+	}
 }
 
 var _ PeerGetter = (*NodeGetter)(nil)
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mproto "github.com/ylt94/mycache/proto"
+)
+
+//在 httptest 上启动节点服务 调用方负责关闭
+func newTestNode(t *testing.T, cfg Config, getter Getter) (*httptest.Server, *mcache) {
+	cache := NewMCache(t.Name(), cfg.Cache, getter)
+	node := NewNodeServer("", cache, cfg)
+	mx := http.NewServeMux()
+	node.handle(mx)
+	return httptest.NewServer(mx), cache
+}
+
+//Handle 通过节点路径前缀和 get 命令读取
+func TestNodeGetterHandle(t *testing.T) {
+	cfg := DefaultConfig()
+	srv, cache := newTestNode(t, cfg, nil)
+	defer srv.Close()
+	defer cache.Close()
+	cache.Set("a b/c", "value")
+
+	g := newNodeGetter(srv.URL, cfg, srv.Client())
+	var out mproto.Response
+	if err := g.Handle(&mproto.Request{Key: "a b/c"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Value) != "value" {
+		t.Fatalf("Handle value = %q; want value", out.Value)
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/ylt94/mycache/consistenthash"
+	"github.com/ylt94/mycache/gossip"
+	"github.com/ylt94/mycache/logger"
+)
+
+const gossipPath = "/gossip"
+
+//设置集群节点 重建一致性hash 节点列表需包含自身
+func (h *NodeServer) SetPeers(peers ...string) {
+	peers = normalizeNodes(peers)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.peers = consistenthash.New(h.cfg.Replicas, nil)
+	h.peers.Add(peers...)
+	getters := make(map[string]*NodeGetter, len(peers))
+	for _, peer := range peers {
+		if g, ok := h.NodeGetters[peer]; ok {
+			getters[peer] = g
+		} else {
+			getters[peer] = newNodeGetter(peer, h.cfg, h.client)
+		}
+	}
+	h.NodeGetters = getters
+}
+
+//返回 key 所属的其他节点 属于自身或未启用无 master 模式时返回 false
+func (h *NodeServer) pickPeer(key string) (*NodeGetter, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.peers == nil {
+		return nil, false
+	}
+	if peer := h.peers.Get(key); peer != "" && peer != h.self {
+		g, ok := h.NodeGetters[peer]
+		return g, ok
+	}
+	return nil, false
+}
+
+func (h *NodeServer) PickPeer(key string) (PeerGetter, bool) {
+	if g, ok := h.pickPeer(key); ok {
+		return g, true
+	}
+	return nil, false
+}
+
+//启用 gossip 成员管理 成员变化时更新一致性hash
+func (h *NodeServer) StartGossip(cfg gossip.Config, seeds []string) (*gossip.Memberlist, error) {
+	cfg.Name = h.self
+	cfg.OnChange = func(members []string) {
+		logger.Info("gossip members changed", logger.F("node", h.self), logger.F("members", members))
+		h.SetPeers(members...)
+	}
+	ml, err := gossip.Create(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) > 0 {
+		if _, err := ml.Join(seeds); err != nil {
+			//种子暂不可达时继续运行 等待其他节点加入
+			logger.Warn("gossip join failed", logger.F("node", h.self), logger.F("err", err))
+		}
+	}
+	return ml, nil
+}
+
+//无 master 模式启动节点
+func GossipStart(srv *NodeServer, seeds []string) {
+	t := gossip.NewHTTPTransport(gossipPath, srv.client)
+	if _, err := srv.StartGossip(gossip.DefaultConfig(srv.self, t), seeds); err != nil {
+		panic(err.Error())
+	}
+	mx := http.NewServeMux()
+	mx.Handle(gossipPath, srv.auth.wrap(t, "register"))
+	srv.handle(mx)
+	if err := listenAndServe(srv.self, mx, srv.cfg.TLS, true); err != nil {
+		panic(err.Error())
+	}
+}
+
+//静态节点模式启动 节点列表通过 SetPeers 设置
+func PeerStart(srv *NodeServer) {
+	mx := http.NewServeMux()
+	srv.handle(mx)
+	if err := listenAndServe(srv.self, mx, srv.cfg.TLS, true); err != nil {
+		panic(err.Error())
+	}
+}
+
+var _ PeerPicker = (*NodeServer)(nil)
@@ -0,0 +1,308 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//service 限流配置 可通过 action=ratelimit 在运行时修改
+type RateLimitConfig struct {
+	ClientRate      float64  `json:"client_rate" usage:"每个客户端每秒请求数 0 不限制"`
+	ClientBurst     int      `json:"client_burst" usage:"每个客户端允许的突发请求数"`
+	PrefixLimits    []string `json:"prefix_limits" usage:"按 key 前缀限流 所有客户端共享 格式 前缀=每秒请求数/突发数"`
+	WriteBytesRate  float64  `json:"write_bytes_rate" usage:"每个客户端每秒写入字节数 0 不限制"`
+	WriteBytesBurst int64    `json:"write_bytes_burst" usage:"每个客户端允许的突发写入字节数"`
+}
+
+var DefaultRateLimitConfig = RateLimitConfig{
+	ClientBurst:     100,
+	WriteBytesBurst: 1 << 20,
+}
+
+//客户端空闲超过该时间后清理其令牌桶
+const rateLimitIdleTimeout = time.Minute
+
+//最多记录的客户端数 清理空闲客户端后仍超过时 新客户端共用一组令牌桶
+const rateLimitMaxClients = 100000
+
+const overflowClient = "overflow"
+
+type prefixLimit struct {
+	prefix string
+	rate   float64
+	burst  int
+}
+
+func parsePrefixLimits(limits []string) ([]prefixLimit, error) {
+	res := make([]prefixLimit, 0, len(limits))
+	for _, s := range limits {
+		i := strings.LastIndex(s, "=")
+		j := strings.LastIndex(s, "/")
+		if i <= 0 || j < i {
+			return nil, fmt.Errorf("prefix limit %q: expected prefix=rate/burst", s)
+		}
+		rate, err := strconv.ParseFloat(s[i+1:j], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("prefix limit %q: invalid rate", s)
+		}
+		burst, err := strconv.Atoi(s[j+1:])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("prefix limit %q: invalid burst", s)
+		}
+		res = append(res, prefixLimit{prefix: s[:i], rate: rate, burst: burst})
+	}
+	//最长前缀优先
+	sort.Slice(res, func(a, b int) bool { return len(res[a].prefix) > len(res[b].prefix) })
+	return res, nil
+}
+
+func (c RateLimitConfig) validate() error {
+	if c.ClientRate < 0 || c.WriteBytesRate < 0 {
+		return fmt.Errorf("ratelimit rates must not be negative")
+	}
+	if c.ClientRate > 0 && c.ClientBurst < 1 {
+		return fmt.Errorf("ratelimit.client_burst must be at least 1")
+	}
+	if c.WriteBytesRate > 0 && c.WriteBytesBurst < 1 {
+		return fmt.Errorf("ratelimit.write_bytes_burst must be at least 1")
+	}
+	_, err := parsePrefixLimits(c.PrefixLimits)
+	return err
+}
+
+//令牌桶 tokens 可以为负 表示超过突发量的大请求预支的额度
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+//还需等待多久才能取出 n 个令牌 超过突发量的请求需要桶满
+func (b *tokenBucket) wait(n float64) time.Duration {
+	need := math.Min(n, b.burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+type clientBuckets struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+	last     time.Time
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	cfg       RateLimitConfig
+	prefixes  []prefixLimit
+	clients   map[string]*clientBuckets
+	byPrefix  map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	l := &rateLimiter{}
+	if err := l.update(cfg); err != nil {
+		panic(err.Error())
+	}
+	return l
+}
+
+//替换配置 已有的令牌桶全部重建
+func (l *rateLimiter) update(cfg RateLimitConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	prefixes, _ := parsePrefixLimits(cfg.PrefixLimits)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+	l.prefixes = prefixes
+	l.clients = make(map[string]*clientBuckets)
+	l.byPrefix = make(map[string]*tokenBucket)
+	return nil
+}
+
+func (l *rateLimiter) config() RateLimitConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+//检查并扣除额度 被限流时返回需要等待的时间及原因
+func (l *rateLimiter) allow(client string, key string, writeBytes int) (time.Duration, string) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	cb := l.clients[client]
+	if cb == nil && len(l.clients) >= rateLimitMaxClients {
+		l.lastSweep = time.Time{}
+		l.sweep(now)
+		if len(l.clients) >= rateLimitMaxClients {
+			client = overflowClient
+			cb = l.clients[client]
+		}
+	}
+	if cb == nil {
+		cb = &clientBuckets{}
+		if l.cfg.ClientRate > 0 {
+			cb.requests = newTokenBucket(l.cfg.ClientRate, float64(l.cfg.ClientBurst), now)
+		}
+		if l.cfg.WriteBytesRate > 0 {
+			cb.bytes = newTokenBucket(l.cfg.WriteBytesRate, float64(l.cfg.WriteBytesBurst), now)
+		}
+		l.clients[client] = cb
+	}
+	cb.last = now
+
+	var pb *tokenBucket
+	if key != "" {
+		for _, p := range l.prefixes {
+			if strings.HasPrefix(key, p.prefix) {
+				if pb = l.byPrefix[p.prefix]; pb == nil {
+					pb = newTokenBucket(p.rate, float64(p.burst), now)
+					l.byPrefix[p.prefix] = pb
+				}
+				break
+			}
+		}
+	}
+
+	//先全部检查再扣除 避免被拒绝的请求消耗其他桶的额度
+	type need struct {
+		b      *tokenBucket
+		n      float64
+		reason string
+	}
+	needs := []need{{cb.requests, 1, "client"}, {pb, 1, "prefix"}}
+	if writeBytes > 0 {
+		needs = append(needs, need{cb.bytes, float64(writeBytes), "bytes"})
+	}
+	for _, n := range needs {
+		if n.b == nil {
+			continue
+		}
+		n.b.refill(now)
+		if d := n.b.wait(n.n); d > 0 {
+			return d, n.reason
+		}
+	}
+	for _, n := range needs {
+		if n.b != nil {
+			n.b.tokens -= n.n
+		}
+	}
+	return 0, ""
+}
+
+//清理长时间空闲的客户端
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitIdleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for client, cb := range l.clients {
+		if now.Sub(cb.last) > rateLimitIdleTimeout {
+			delete(l.clients, client)
+		}
+	}
+}
+
+//客户端标识 identity 为认证通过的凭证标识 未启用认证时为来源 ip
+//不能直接使用请求头 否则客户端可以伪造任意多个标识绕过限流
+func clientIdentity(r *http.Request, identity string) string {
+	if identity != "" {
+		return identity
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:4])
+}
+
+//写命令计入字节额度的大小
+func writeSize(action string, values url.Values) int {
+	if perm, ok := actionPermissions[strings.ToLower(action)]; ok && perm == permRead {
+		return 0
+	}
+	n := len(values.Get("key")) + len(values.Get("field"))
+	for _, v := range values["value"] {
+		n += len(v)
+	}
+	return n
+}
+
+//被限流时返回 429
+func writeRateLimited(w http.ResponseWriter, wait time.Duration, reason string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "rate limited: "+reason, http.StatusTooManyRequests)
+}
+
+//查看或修改限流配置 带参数时修改 未带的参数保持不变
+func (m *service) serveRateLimit(w http.ResponseWriter, values url.Values) {
+	cfg := m.limiter.config()
+	var err error
+	parseFloat := func(name string, dst *float64) {
+		if s := values.Get(name); s != "" && err == nil {
+			*dst, err = strconv.ParseFloat(s, 64)
+		}
+	}
+	parseInt := func(name string, dst *int64) {
+		if s := values.Get(name); s != "" && err == nil {
+			*dst, err = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	burst := int64(cfg.ClientBurst)
+	parseFloat("client_rate", &cfg.ClientRate)
+	parseInt("client_burst", &burst)
+	parseFloat("write_bytes_rate", &cfg.WriteBytesRate)
+	parseInt("write_bytes_burst", &cfg.WriteBytesBurst)
+	cfg.ClientBurst = int(burst)
+	if limits, ok := values["prefix_limits"]; ok {
+		cfg.PrefixLimits = make([]string, 0)
+		for _, s := range limits {
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					cfg.PrefixLimits = append(cfg.PrefixLimits, item)
+				}
+			}
+		}
+	}
+	if err == nil && len(values) > 1 {
+		err = m.limiter.update(cfg)
+	}
+	if err != nil {
+		w.Write([]byte("ratelimit error:" + err.Error()))
+		return
+	}
+	writeJSON(w, cfg)
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//按时间补充令牌 不超过突发量 超过突发量的请求需要等桶满
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(10, 5, start)
+	if d := b.wait(5); d != 0 {
+		t.Fatalf("full bucket wait(5) = %v; want 0", d)
+	}
+	b.tokens -= 5
+	if d := b.wait(1); d != 100*time.Millisecond {
+		t.Fatalf("empty bucket wait(1) = %v; want 100ms", d)
+	}
+
+	b.refill(start.Add(200 * time.Millisecond))
+	if math.Abs(b.tokens-2) > 1e-9 {
+		t.Fatalf("tokens after 200ms = %v; want 2", b.tokens)
+	}
+	b.refill(start.Add(time.Hour))
+	if b.tokens != 5 {
+		t.Fatalf("tokens after an hour = %v; want burst 5", b.tokens)
+	}
+
+	//大请求只需等到桶满 扣除后为负 后续请求需等待补齐
+	if d := b.wait(20); d != 0 {
+		t.Fatalf("wait(20) on full bucket = %v; want 0", d)
+	}
+	b.tokens -= 20
+	if d := b.wait(1); d != 1600*time.Millisecond {
+		t.Fatalf("wait(1) after overdraft = %v; want 1.6s", d)
+	}
+}
+
+func TestRateLimiterClient(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{ClientRate: 1, ClientBurst: 2, WriteBytesRate: 100, WriteBytesBurst: 10})
+	for i := 0; i < 2; i++ {
+		if d, _ := l.allow("a", "k", 0); d != 0 {
+			t.Fatalf("request %d limited for %v", i, d)
+		}
+	}
+	if d, reason := l.allow("a", "k", 0); d <= 0 || reason != "client" {
+		t.Fatalf("third request = %v, %q; want limited by client", d, reason)
+	}
+	//客户端之间互不影响
+	if d, _ := l.allow("b", "k", 0); d != 0 {
+		t.Fatalf("other client limited for %v", d)
+	}
+
+	if d, _ := l.allow("c", "k", 10); d != 0 {
+		t.Fatalf("write within burst limited for %v", d)
+	}
+	if d, reason := l.allow("c", "k", 5); d <= 0 || reason != "bytes" {
+		t.Fatalf("write over byte budget = %v, %q; want limited by bytes", d, reason)
+	}
+	//被拒绝的请求不消耗请求额度
+	if d, _ := l.allow("c", "k", 0); d != 0 {
+		t.Fatalf("read after rejected write limited for %v", d)
+	}
+}
+
+func TestRateLimiterPrefix(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{PrefixLimits: []string{"user:=1/1", "user:vip:=100/100"}})
+	if d, _ := l.allow("a", "user:1", 0); d != 0 {
+		t.Fatalf("first user request limited for %v", d)
+	}
+	//前缀额度由所有客户端共享
+	if d, reason := l.allow("b", "user:2", 0); d <= 0 || reason != "prefix" {
+		t.Fatalf("second user request = %v, %q; want limited by prefix", d, reason)
+	}
+	//最长前缀优先
+	if d, _ := l.allow("b", "user:vip:1", 0); d != 0 {
+		t.Fatalf("vip request limited for %v", d)
+	}
+	if d, _ := l.allow("b", "other", 0); d != 0 {
+		t.Fatalf("request without prefix limit limited for %v", d)
+	}
+}
+
+//客户端数达到上限后新客户端共用一组令牌桶 空闲的客户端被清理后恢复
+func TestRateLimiterMaxClients(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{ClientRate: 1, ClientBurst: 1})
+	now := time.Now()
+	for i := 0; i < rateLimitMaxClients; i++ {
+		l.clients[strconv.Itoa(i)] = &clientBuckets{last: now}
+	}
+
+	if d, _ := l.allow("new1", "", 0); d != 0 {
+		t.Fatalf("first overflow request limited for %v", d)
+	}
+	if _, ok := l.clients["new1"]; ok {
+		t.Fatal("client tracked beyond the cap")
+	}
+	if d, reason := l.allow("new2", "", 0); d <= 0 || reason != "client" {
+		t.Fatalf("second overflow request = %v, %q; want sharing the overflow bucket", d, reason)
+	}
+	//已记录的客户端不受影响
+	if d, _ := l.allow("0", "", 0); d != 0 {
+		t.Fatalf("tracked client limited for %v", d)
+	}
+
+	idle := now.Add(-2 * rateLimitIdleTimeout)
+	for _, cb := range l.clients {
+		cb.last = idle
+	}
+	if d, _ := l.allow("new3", "", 0); d != 0 {
+		t.Fatalf("request after idle clients expired limited for %v", d)
+	}
+	if _, ok := l.clients["new3"]; !ok || len(l.clients) != 1 {
+		t.Fatalf("%d clients after sweep, new3 tracked %v; want 1, true", len(l.clients), ok)
+	}
+}
+
+//启用认证时按凭证标识限流 否则按来源 ip 忽略请求头
+func TestClientIdentity(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	if got := clientIdentity(r, ""); got != "ip:10.0.0.1" {
+		t.Fatalf("clientIdentity = %q; want ip:10.0.0.1", got)
+	}
+	if got := clientIdentity(r, "key:k1"); got != "key:k1" {
+		t.Fatalf("clientIdentity = %q; want key:k1", got)
+	}
+}
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultScanCount = 10
+
+//scan 返回结果 cursor 为空表示遍历结束
+type scanResult struct {
+	Cursor string   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+//按 key 字典序遍历 cursor 为上次返回的最后一个 key
+//只保留大于 cursor 的最小 count+1 个匹配的 key 每页 O(n log count) 不对全部 key 排序
+func (c *cache) scan(cursor string, count int, match func(key string) bool) scanResult {
+	c.mu.RLock()
+	var keys []string
+	if c.lru != nil {
+		keys = c.keys()
+	}
+	c.mu.RUnlock()
+
+	h := &maxHeap{}
+	for _, key := range keys {
+		if key <= cursor || (h.Len() > count && key >= (*h)[0]) {
+			continue
+		}
+		if match != nil && !match(key) {
+			continue
+		}
+		heap.Push(h, key)
+		if h.Len() > count+1 {
+			heap.Pop(h)
+		}
+	}
+	//多出的一个说明还有下一页
+	more := h.Len() > count
+	if more {
+		heap.Pop(h)
+	}
+	res := scanResult{Keys: make([]string, h.Len())}
+	for i := len(res.Keys) - 1; i >= 0; i-- {
+		res.Keys[i] = heap.Pop(h).(string)
+	}
+	if more {
+		res.Cursor = res.Keys[len(res.Keys)-1]
+	}
+	return res
+}
+
+//字符串大顶堆
+type maxHeap []string
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func (g *mcache) Scan(cursor string, count int, prefix string, pattern string) (scanResult, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	if err := validGlob(pattern); err != nil {
+		return scanResult{}, err
+	}
+	return g.baseCache.scan(cursor, count, func(key string) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if pattern == "" {
+			return true
+		}
+		ok, _ := matchGlob(pattern, key)
+		return ok
+	}), nil
+}
+
+//检查 glob 语法 语法正确时 matchGlob 不会返回错误
+func validGlob(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return fmt.Errorf("bad pattern: unclosed [")
+			}
+			i += end + 1
+		case '\\':
+			if i+1 >= len(pattern) {
+				return fmt.Errorf("bad pattern: trailing \\")
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+//glob 匹配 支持 * ? [abc] [a-z] 以及 \ 转义
+func matchGlob(pattern string, s string) (bool, error) {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true, nil
+			}
+			for i := 0; i <= len(s); i++ {
+				ok, err := matchGlob(pattern, s[i:])
+				if ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		case '?':
+			if len(s) == 0 {
+				return false, nil
+			}
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return false, fmt.Errorf("bad pattern: unclosed [")
+			}
+			class := pattern[1 : end+1]
+			if len(s) == 0 || !matchClass(class, s[0]) {
+				return false, nil
+			}
+			pattern = pattern[end+2:]
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) < 2 {
+				return false, fmt.Errorf("bad pattern: trailing \\")
+			}
+			pattern = pattern[1:]
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false, nil
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0, nil
+}
+
+func matchClass(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				return !negate
+			}
+			i += 2
+		} else if class[i] == c {
+			return !negate
+		}
+	}
+	return negate
+}
+
+//节点 scan 命令
+func (h *NodeServer) serveScan(w http.ResponseWriter, values url.Values) {
+	count, _ := strconv.Atoi(values.Get("count"))
+	res, err := h.mainCache.Scan(values.Get("cursor"), count, values.Get("prefix"), values.Get("match"))
+	if err != nil {
+		w.Write([]byte("scan error:" + err.Error()))
+		return
+	}
+	writeJSON(w, res)
+}
+
+//从节点 scan
+func (g *NodeGetter) scan(ctx context.Context, values url.Values) (scanResult, error) {
+	var res scanResult
+	q := url.Values{}
+	for _, k := range []string{"count", "prefix", "match"} {
+		q.Set(k, values.Get(k))
+	}
+	q.Set("action", "scan")
+	q.Set("cursor", values.Get("cursor"))
+	bytes, err := g.do(ctx, q)
+	if err != nil {
+		return res, err
+	}
+	if err := json.Unmarshal(bytes, &res); err != nil {
+		return res, fmt.Errorf("node scan returned:%s", string(bytes))
+	}
+	return res, nil
+}
+
+//集群 scan 按节点名依次遍历 cursor 格式为 节点名#节点内cursor
+func (m *service) serveScan(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	if err := validGlob(values.Get("match")); err != nil {
+		w.Write([]byte("scan error:" + err.Error()))
+		return
+	}
+	node, cursor := "", ""
+	if c := values.Get("cursor"); c != "" {
+		i := strings.IndexByte(c, '#')
+		if i < 0 {
+			w.Write([]byte("scan error:invalid cursor"))
+			return
+		}
+		node, cursor = c[:i], c[i+1:]
+	}
+
+	names := m.mserver.nodeNames()
+	i := sort.SearchStrings(names, node)
+	if i >= len(names) {
+		writeJSON(w, scanResult{Keys: make([]string, 0)})
+		return
+	}
+	if names[i] != node {
+		cursor = ""
+	}
+	getter, ok := m.mserver.getNodeByName(names[i])
+	if !ok {
+		w.Write([]byte("scan error:no such cache node:" + names[i]))
+		return
+	}
+	values.Set("cursor", cursor)
+	res, err := getter.scan(r.Context(), values)
+	if err != nil {
+		w.Write([]byte("scan error:" + err.Error()))
+		return
+	}
+	if res.Cursor != "" {
+		res.Cursor = names[i] + "#" + res.Cursor
+	} else if i+1 < len(names) {
+		res.Cursor = names[i+1] + "#"
+	}
+	writeJSON(w, res)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"*:1", "user:1", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"a**c", "abc", true},
+		{"?", "a", true},
+		{"?", "", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[abc]", "b", true},
+		{"[abc]", "d", false},
+		{"[a-c]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{"[^a-c]", "d", true},
+		{"[^a-c]", "a", false},
+		{"[^a-c]", "", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\?`, "a?", true},
+	}
+	for _, c := range cases {
+		got, err := matchGlob(c.pattern, c.s)
+		if err != nil || got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, %v; want %v", c.pattern, c.s, got, err, c.want)
+		}
+	}
+
+	for _, p := range []string{"[abc", `abc\`} {
+		if err := validGlob(p); err == nil {
+			t.Errorf("validGlob(%q): want error", p)
+		}
+		if _, err := matchGlob(p, "abc"); err == nil {
+			t.Errorf("matchGlob(%q): want error", p)
+		}
+	}
+}
+
+//分页遍历所有 key 返回拼接后的结果 每页调用 between
+func scanAll(t *testing.T, g *mcache, count int, prefix, match string, between func(page int, cursor string)) []string {
+	t.Helper()
+	var keys []string
+	cursor := ""
+	for page := 0; ; page++ {
+		res, err := g.Scan(cursor, count, prefix, match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Keys) > count {
+			t.Fatalf("page %d: %d keys; want at most %d", page, len(res.Keys), count)
+		}
+		keys = append(keys, res.Keys...)
+		if res.Cursor == "" {
+			return keys
+		}
+		if res.Cursor != res.Keys[len(res.Keys)-1] {
+			t.Fatalf("page %d: cursor %q is not the last key %q", page, res.Cursor, res.Keys[len(res.Keys)-1])
+		}
+		cursor = res.Cursor
+		if between != nil {
+			between(page, cursor)
+		}
+		if page > 1000 {
+			t.Fatal("scan did not finish")
+		}
+	}
+}
+
+func fillKeys(g *mcache, n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%03d", i)
+		g.Set(keys[i], "v")
+	}
+	return keys
+}
+
+//分页按字典序返回 每个 key 只出现一次
+func TestScanPaging(t *testing.T) {
+	for _, engine := range []string{engineLRU, engineArena} {
+		t.Run(engine, func(t *testing.T) {
+			g := testCache(t, engine)
+			defer g.Close()
+			want := fillKeys(g, 25)
+
+			for _, count := range []int{1, 3, 5, 25, 100} {
+				got := scanAll(t, g, count, "", "", nil)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("count %d: scan = %v; want %v", count, got, want)
+				}
+			}
+
+			g.Set("other", "v")
+			if got := scanAll(t, g, 4, "k01", "", nil); !reflect.DeepEqual(got, want[10:20]) {
+				t.Fatalf("prefix scan = %v; want %v", got, want[10:20])
+			}
+			if got := scanAll(t, g, 4, "", "k0?5", nil); !reflect.DeepEqual(got, []string{"k005", "k015"}) {
+				t.Fatalf("match scan = %v; want [k005 k015]", got)
+			}
+		})
+	}
+}
+
+//遍历期间写入和删除 key 已返回的 key 不重复 未返回且未删除的 key 不丢失
+func TestScanConcurrentChanges(t *testing.T) {
+	g := testCache(t, engineLRU)
+	defer g.Close()
+	keys := fillKeys(g, 30)
+
+	got := scanAll(t, g, 4, "", "", func(page int, cursor string) {
+		switch page {
+		case 0:
+			//cursor 之前与之后各写入一个
+			g.Set("k000a", "v")
+			g.Set("k020a", "v")
+		case 1:
+			//删除 cursor 本身和之后的 key 不影响遍历
+			g.Del(cursor)
+			g.Del("k025")
+		}
+	})
+
+	seen := make(map[string]bool)
+	for _, k := range got {
+		if seen[k] {
+			t.Fatalf("key %s returned twice", k)
+		}
+		seen[k] = true
+	}
+	if !sort.StringsAreSorted(got) {
+		t.Fatalf("scan not in order: %v", got)
+	}
+	if seen["k000a"] {
+		t.Fatal("key inserted before the cursor was returned")
+	}
+	if !seen["k020a"] {
+		t.Fatal("key inserted after the cursor was not returned")
+	}
+	if seen["k025"] {
+		t.Fatal("key deleted before it was reached was returned")
+	}
+	for _, k := range keys {
+		if k != "k025" && !seen[k] {
+			t.Fatalf("key %s missing from scan", k)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ylt94/mycache/logger"
+)
+
+//getter 加载的数据的有效期
+type ttlOptions struct {
+	soft         time.Duration
+	hard         time.Duration
+	staleOnError bool
+}
+
+//是否超过 soft/hard TTL 调用方写入的数据不会过期
+func (o ttlOptions) expired(v ByteView) (soft bool, hard bool) {
+	if v.loaded == 0 {
+		return false, false
+	}
+	age := time.Duration(time.Now().UnixNano() - v.loaded)
+	return o.soft > 0 && age >= o.soft, o.hard > 0 && age >= o.hard
+}
+
+//后台刷新 同一个 key 同时只有一个刷新 与前台加载共用 singleflight
+//上次加载失败留下的负缓存未过期时不刷新 刷新失败的重试间隔由 ErrorTTL 控制
+//getter 返回 ErrNotFound 时删除旧数据
+func (g *mcache) refresh(key string) {
+	if g.negative.has(key) {
+		return
+	}
+	g.refreshMu.Lock()
+	if g.refreshing[key] {
+		g.refreshMu.Unlock()
+		return
+	}
+	g.refreshing[key] = true
+	g.refreshMu.Unlock()
+
+	atomic.AddInt64(&g.counters.refreshes, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("[mcache] background refresh panic", logger.F("key", key), logger.F("panic", r))
+			}
+			g.refreshMu.Lock()
+			delete(g.refreshing, key)
+			g.refreshMu.Unlock()
+		}()
+		_, ver, ok := g.baseCache.getWithVersion(key)
+		_, err := g.load(context.Background(), key)
+		if err == nil {
+			return
+		}
+		logger.Warn("[mcache] background refresh failed", logger.F("key", key), logger.F("err", err))
+		//期间被重新写入的数据版本号不同 不会被删除
+		if ok && errors.Is(err, ErrNotFound) {
+			g.baseCache.delIfVersion(key, ver)
+		}
+	}()
+}
+
+//加载失败时按配置返回过期的旧数据 getter 返回 ErrNotFound 时不返回
+func (g *mcache) serveStale(key string, stale *ByteView, err error) ([]byte, error) {
+	if stale == nil || !g.ttl.staleOnError || errors.Is(err, ErrNotFound) {
+		return make([]byte, 0), err
+	}
+	atomic.AddInt64(&g.counters.staleOnErr, 1)
+	logger.Warn("[mcache] load failed, serving stale value", logger.F("key", key), logger.F("err", err))
+	return decode(*stale)
+}
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+//可以随时替换结果的 getter
+type scriptedGetter struct {
+	mu    sync.Mutex
+	fn    func(key string) ([]byte, error)
+	calls int
+}
+
+func (g *scriptedGetter) Get(key string) ([]byte, error) {
+	g.mu.Lock()
+	g.calls++
+	fn := g.fn
+	g.mu.Unlock()
+	return fn(key)
+}
+
+func (g *scriptedGetter) set(fn func(key string) ([]byte, error)) {
+	g.mu.Lock()
+	g.fn = fn
+	g.mu.Unlock()
+}
+
+func (g *scriptedGetter) count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+func returns(value string, err error) func(key string) ([]byte, error) {
+	return func(key string) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		return []byte(value), nil
+	}
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func (g *mcache) refreshDone(key string) bool {
+	g.refreshMu.Lock()
+	defer g.refreshMu.Unlock()
+	return !g.refreshing[key]
+}
+
+func getString(t *testing.T, g *mcache, key string) string {
+	t.Helper()
+	b, err := g.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	return string(b)
+}
+
+const testTTL = 30 * time.Millisecond
+
+//超过 soft TTL 先返回旧数据 后台刷新后返回新数据
+func TestSoftTTL(t *testing.T) {
+	getter := &scriptedGetter{fn: returns("v1", nil)}
+	g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, SoftTTL: testTTL}, getter)
+	defer g.Close()
+
+	if v := getString(t, g, "k"); v != "v1" {
+		t.Fatalf("first Get = %q; want v1", v)
+	}
+	getter.set(returns("v2", nil))
+	if v := getString(t, g, "k"); v != "v1" {
+		t.Fatalf("Get within soft TTL = %q; want v1", v)
+	}
+	time.Sleep(testTTL + 10*time.Millisecond)
+	if v := getString(t, g, "k"); v != "v1" {
+		t.Fatalf("Get after soft TTL = %q; want stale v1", v)
+	}
+	eventually(t, "refresh", func() bool { return g.refreshDone("k") })
+	if v := getString(t, g, "k"); v != "v2" {
+		t.Fatalf("Get after refresh = %q; want v2", v)
+	}
+	if s := g.Stats(); s.StaleHits != 1 || s.Refreshes != 1 || getter.count() != 2 {
+		t.Fatalf("stale hits %d refreshes %d loads %d; want 1, 1, 2", s.StaleHits, s.Refreshes, getter.count())
+	}
+
+	//调用方写入的数据不会过期
+	g.Set("written", "w")
+	time.Sleep(testTTL + 10*time.Millisecond)
+	getString(t, g, "written")
+	if s := g.Stats(); s.Refreshes != 1 {
+		t.Fatalf("written value refreshed")
+	}
+}
+
+//超过 hard TTL 等待重新加载 失败时按 StaleOnError 返回旧数据 不存在时不返回
+func TestHardTTL(t *testing.T) {
+	cases := []struct {
+		name         string
+		staleOnError bool
+		reload       func(key string) ([]byte, error)
+		want         string
+		wantErr      error
+	}{
+		{"reload", false, returns("v2", nil), "v2", nil},
+		{"error", false, returns("", errBackend), "", errBackend},
+		{"stale on error", true, returns("", errBackend), "v1", nil},
+		{"not found is not served stale", true, returns("", ErrNotFound), "", ErrNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			getter := &scriptedGetter{fn: returns("v1", nil)}
+			g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, HardTTL: testTTL, StaleOnError: c.staleOnError}, getter)
+			defer g.Close()
+			getString(t, g, "k")
+			getter.set(c.reload)
+			time.Sleep(testTTL + 10*time.Millisecond)
+
+			b, err := g.Get("k")
+			if string(b) != c.want || err != c.wantErr {
+				t.Fatalf("Get after hard TTL = %q, %v; want %q, %v", b, err, c.want, c.wantErr)
+			}
+			if getter.count() != 2 {
+				t.Fatalf("getter called %d times; want 2", getter.count())
+			}
+		})
+	}
+}
+
+//刷新失败留下的负缓存未过期时不再刷新
+func TestRefreshSkippedWhileNegativeCached(t *testing.T) {
+	getter := &scriptedGetter{fn: returns("v1", nil)}
+	g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, SoftTTL: testTTL, ErrorTTL: time.Minute}, getter)
+	defer g.Close()
+	getString(t, g, "k")
+	getter.set(returns("", errBackend))
+	time.Sleep(testTTL + 10*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		if v := getString(t, g, "k"); v != "v1" {
+			t.Fatalf("Get = %q; want stale v1", v)
+		}
+		eventually(t, "refresh", func() bool { return g.refreshDone("k") })
+	}
+	if s := g.Stats(); s.Refreshes != 1 || getter.count() != 2 {
+		t.Fatalf("refreshes %d loads %d; want 1, 2", s.Refreshes, getter.count())
+	}
+}
+
+//刷新时 getter 返回 ErrNotFound 删除旧数据 刷新期间被重新写入的数据保留
+func TestRefreshNotFound(t *testing.T) {
+	getter := &scriptedGetter{fn: returns("v1", nil)}
+	g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, SoftTTL: testTTL}, getter)
+	defer g.Close()
+	getString(t, g, "gone")
+	getString(t, g, "rewritten")
+	time.Sleep(testTTL + 10*time.Millisecond)
+
+	getter.set(returns("", ErrNotFound))
+	getString(t, g, "gone")
+	eventually(t, "gone to be deleted", func() bool {
+		_, ok := g.baseCache.lookup("gone")
+		return !ok
+	})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	getter.set(func(key string) ([]byte, error) {
+		close(started)
+		<-release
+		return nil, ErrNotFound
+	})
+	getString(t, g, "rewritten")
+	<-started
+	g.Set("rewritten", "new")
+	close(release)
+	eventually(t, "refresh", func() bool { return g.refreshDone("rewritten") })
+	if v := getString(t, g, "rewritten"); v != "new" {
+		t.Fatalf("Get after refresh = %q; want the value written during the refresh", v)
+	}
+}
+
+//getter panic 时刷新协程恢复 之后仍可再次刷新
+func TestRefreshPanic(t *testing.T) {
+	getter := &scriptedGetter{fn: returns("v1", nil)}
+	g := NewMCache(t.Name(), CacheConfig{MaxBytes: 1 << 20, SoftTTL: testTTL}, getter)
+	defer g.Close()
+	getString(t, g, "k")
+	getter.set(func(key string) ([]byte, error) { panic("boom") })
+	time.Sleep(testTTL + 10*time.Millisecond)
+
+	if v := getString(t, g, "k"); v != "v1" {
+		t.Fatalf("Get = %q; want stale v1", v)
+	}
+	eventually(t, "panicking refresh to finish", func() bool { return g.refreshDone("k") })
+
+	getter.set(returns("v2", nil))
+	getString(t, g, "k")
+	eventually(t, "refresh", func() bool { return g.refreshDone("k") })
+	if v := getString(t, g, "k"); v != "v2" {
+		t.Fatalf("Get after second refresh = %q; want v2", v)
+	}
+}
+
+//阻塞到 ctx 结束的数据源
+type blockingGetter struct{}
+
+func (blockingGetter) Get(key string) ([]byte, error) {
+	select {}
+}
+
+func (blockingGetter) GetContext(ctx context.Context, key string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+//按前缀设置的加载超时 最长前缀优先 未匹配的使用 LoadTimeout
+func TestLoadTimeouts(t *testing.T) {
+	g := NewMCache(t.Name(), CacheConfig{
+		MaxBytes:     1 << 20,
+		LoadTimeout:  100 * time.Millisecond,
+		LoadTimeouts: []string{"user:=300ms", "user:vip:=20ms"},
+	}, blockingGetter{})
+	defer g.Close()
+
+	cases := []struct {
+		key      string
+		min, max time.Duration
+	}{
+		{"user:vip:1", 20 * time.Millisecond, 90 * time.Millisecond},
+		{"order:1", 100 * time.Millisecond, 290 * time.Millisecond},
+		{"user:1", 300 * time.Millisecond, time.Second},
+	}
+	var wg sync.WaitGroup
+	for _, c := range cases {
+		wg.Add(1)
+		go func(key string, min, max time.Duration) {
+			defer wg.Done()
+			start := time.Now()
+			_, err := g.Get(key)
+			d := time.Since(start)
+			if err != context.DeadlineExceeded || d < min || d > max {
+				t.Errorf("Get(%s) = %v after %v; want DeadlineExceeded within [%v, %v]", key, err, d, min, max)
+			}
+		}(c.key, c.min, c.max)
+	}
+	wg.Wait()
+
+	for _, bad := range []string{"user:", "user:=abc", "user:=-1s", "user:=0s"} {
+		if _, err := parseLoadTimeouts([]string{bad}); err == nil {
+			t.Errorf("parseLoadTimeouts(%q): want error", bad)
+		}
+	}
+}
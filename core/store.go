@@ -0,0 +1,174 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ylt94/mycache/arena"
+	"github.com/ylt94/mycache/lru"
+)
+
+var ErrUnsupportedType = errors.New("value type not supported by storage engine")
+
+var ErrValueTooLarge = errors.New("value larger than storage engine capacity")
+
+//存储引擎
+const (
+	engineLRU   = "lru"
+	engineArena = "arena"
+)
+
+//底层存储 调用方需持有 cache 的锁
+type store interface {
+	Add(key string, value lru.Value)
+	AddWithVersion(key string, value lru.Value, version uint64)
+	Get(key string) (lru.Value, bool)
+	Peek(key string) (lru.Value, bool)
+	GetWithVersion(key string) (lru.Value, uint64, bool)
+	AddIfVersion(key string, value lru.Value, version uint64) (uint64, bool)
+	AddIfAbsent(key string, value lru.Value) (uint64, bool)
+	AddIfPresent(key string, value lru.Value) (uint64, bool)
+	DelIfVersion(key string, version uint64) (bool, error)
+	Del(key string) (bool, error)
+	Keys() []string
+	UsedBytes() int64
+	RemoveOldest() (int64, bool)
+	Stats() lru.Stats
+}
+
+var _ store = (*lru.Cache)(nil)
+var _ store = (*arenaStore)(nil)
+
+//onEvicted 不为空时在淘汰时代替 onRemoved 调用
+func newStore(engine string, maxBytes int64, onRemoved func(key string, value lru.Value), onEvicted func(key string, value lru.Value, version uint64)) store {
+	if engine == engineArena {
+		c := arena.New(maxBytes, func(key string) { onRemoved(key, nil) })
+		if onEvicted != nil {
+			c.SetOnEvicted(func(key string, b []byte, version uint64) { onEvicted(key, unmarshalView(b), version) })
+		}
+		return &arenaStore{c}
+	}
+	c := lru.New(maxBytes, onRemoved)
+	if onEvicted != nil {
+		c.SetOnEvicted(onEvicted)
+	}
+	return c
+}
+
+//arena 引擎只保存字符串类型的值 写入前需通过 check 校验 校验不通过的值不会写入
+type arenaStore struct {
+	c *arena.Cache
+}
+
+//能否写入 value
+func (s *arenaStore) check(key string, value lru.Value) error {
+	b, ok := value.(ByteView)
+	if !ok {
+		return ErrUnsupportedType
+	}
+	if !s.c.Fits(key, viewHeaderSize+len(b.b)) {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+func (s *arenaStore) Add(key string, value lru.Value) {
+	s.set(key, value)
+}
+
+func (s *arenaStore) AddWithVersion(key string, value lru.Value, version uint64) {
+	if s.check(key, value) == nil {
+		s.c.SetWithVersion(key, value.(ByteView).marshal(), version)
+	}
+}
+
+func (s *arenaStore) set(key string, value lru.Value) (uint64, error) {
+	if err := s.check(key, value); err != nil {
+		return 0, err
+	}
+	return s.c.Set(key, value.(ByteView).marshal())
+}
+
+func (s *arenaStore) Get(key string) (lru.Value, bool) {
+	b, _, ok := s.c.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return unmarshalView(b), true
+}
+
+func (s *arenaStore) Peek(key string) (lru.Value, bool) {
+	b, _, ok := s.c.Peek(key)
+	if !ok {
+		return nil, false
+	}
+	return unmarshalView(b), true
+}
+
+func (s *arenaStore) GetWithVersion(key string) (lru.Value, uint64, bool) {
+	b, ver, ok := s.c.Get(key)
+	if !ok {
+		return nil, 0, false
+	}
+	return unmarshalView(b), ver, true
+}
+
+//版本号一致时才写入 version 为 0 表示要求 key 不存在
+func (s *arenaStore) AddIfVersion(key string, value lru.Value, version uint64) (uint64, bool) {
+	_, cur, _ := s.c.Peek(key)
+	if cur != version {
+		return cur, false
+	}
+	ver, err := s.set(key, value)
+	return ver, err == nil
+}
+
+func (s *arenaStore) AddIfAbsent(key string, value lru.Value) (uint64, bool) {
+	if _, cur, ok := s.c.Peek(key); ok {
+		return cur, false
+	}
+	ver, err := s.set(key, value)
+	return ver, err == nil
+}
+
+func (s *arenaStore) AddIfPresent(key string, value lru.Value) (uint64, bool) {
+	if _, _, ok := s.c.Peek(key); !ok {
+		return 0, false
+	}
+	ver, err := s.set(key, value)
+	return ver, err == nil
+}
+
+func (s *arenaStore) DelIfVersion(key string, version uint64) (bool, error) {
+	_, cur, ok := s.c.Peek(key)
+	if !ok {
+		return false, fmt.Errorf("data not exists")
+	}
+	if cur != version {
+		return false, nil
+	}
+	return s.Del(key)
+}
+
+func (s *arenaStore) Del(key string) (bool, error) {
+	if !s.c.Del(key) {
+		return true, fmt.Errorf("data not exists")
+	}
+	return true, nil
+}
+
+func (s *arenaStore) Keys() []string {
+	return s.c.Keys()
+}
+
+func (s *arenaStore) UsedBytes() int64 {
+	return s.c.UsedBytes()
+}
+
+func (s *arenaStore) RemoveOldest() (int64, bool) {
+	return s.c.RemoveOldest()
+}
+
+func (s *arenaStore) Stats() lru.Stats {
+	return s.c.Stats()
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ylt94/mycache/logger"
+)
+
+//TLS 配置 设置证书后 https:// 地址使用 TLS 监听和访问
+type TLSConfig struct {
+	CertFile       string        `json:"cert_file" usage:"证书文件 节点证书需同时可用于服务端与客户端"`
+	KeyFile        string        `json:"key_file" usage:"私钥文件"`
+	CAFile         string        `json:"ca_file" usage:"用于校验对端证书的 CA 为空使用系统根证书"`
+	ClientAuth     bool          `json:"client_auth" usage:"master 与节点要求对端出示 CA 签发的证书(mTLS)"`
+	ReloadInterval time.Duration `json:"reload_interval" usage:"检查证书文件变化的间隔 变化后自动重新加载"`
+}
+
+var DefaultTLSConfig = TLSConfig{
+	ReloadInterval: 10 * time.Second,
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+//证书与 CA 文件 握手时按间隔检查文件变化并重新加载
+type tlsFiles struct {
+	cfg     TLSConfig
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	stamps  map[string]string //文件修改时间与大小
+	checked time.Time
+}
+
+var (
+	tlsMu    sync.Mutex
+	tlsCache = make(map[TLSConfig]*tlsFiles)
+)
+
+//同一份配置在进程内只加载一次
+func loadTLSFiles(c TLSConfig) (*tlsFiles, error) {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	if f, ok := tlsCache[c]; ok {
+		return f, nil
+	}
+	f := &tlsFiles{cfg: c}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	f.checked = time.Now()
+	tlsCache[c] = f
+	return f, nil
+}
+
+func (f *tlsFiles) load() error {
+	cert, err := tls.LoadX509KeyPair(f.cfg.CertFile, f.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls cert: %v", err)
+	}
+	var pool *x509.CertPool
+	if f.cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(f.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("load tls ca: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load tls ca: no certificates in %s", f.cfg.CAFile)
+		}
+	}
+	f.cert, f.pool, f.stamps = &cert, pool, f.stat()
+	return nil
+}
+
+func (f *tlsFiles) stat() map[string]string {
+	stamps := make(map[string]string, 3)
+	for _, p := range []string{f.cfg.CertFile, f.cfg.KeyFile, f.cfg.CAFile} {
+		if fi, err := os.Stat(p); err == nil {
+			stamps[p] = fmt.Sprintf("%d/%d", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return stamps
+}
+
+//当前证书 文件有变化时重新加载 加载失败继续使用旧证书
+func (f *tlsFiles) get() (*tls.Certificate, *x509.CertPool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now := time.Now(); now.Sub(f.checked) >= f.cfg.ReloadInterval {
+		f.checked = now
+		if changed(f.stamps, f.stat()) {
+			if err := f.load(); err != nil {
+				logger.Error("reload tls files failed", logger.F("cert", f.cfg.CertFile), logger.F("err", err))
+			} else {
+				logger.Info("tls files reloaded", logger.F("cert", f.cfg.CertFile))
+			}
+		}
+	}
+	return f.cert, f.pool
+}
+
+func changed(old, cur map[string]string) bool {
+	if len(old) != len(cur) {
+		return true
+	}
+	for k, v := range cur {
+		if old[k] != v {
+			return true
+		}
+	}
+	return false
+}
+
+//服务端配置 clientAuth 为 true 时要求并校验客户端证书
+func (f *tlsFiles) serverConfig(clientAuth bool) *tls.Config {
+	current := func() *tls.Config {
+		cert, pool := f.get()
+		c := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*cert}, ClientCAs: pool}
+		if clientAuth {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return c
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := f.get()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return current(), nil
+		},
+	}
+}
+
+//客户端配置 始终出示自己的证书 供对端做 mTLS 校验
+func (f *tlsFiles) clientConfig() *tls.Config {
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := f.get()
+			return cert, nil
+		},
+	}
+	if f.cfg.CAFile == "" {
+		return c
+	}
+	//使用重新加载后的 CA 校验 因此跳过默认校验 在 VerifyConnection 中完成
+	c.InsecureSkipVerify = true
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("tls: no peer certificate")
+		}
+		_, pool := f.get()
+		opts := x509.VerifyOptions{DNSName: cs.ServerName, Roots: pool, Intermediates: x509.NewCertPool()}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return c
+}
+
+//访问其他节点使用的 TLS 配置 未启用时返回 nil
+func clientTLSConfig(c TLSConfig) (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, nil
+	}
+	f, err := loadTLSFiles(c)
+	if err != nil {
+		return nil, fmt.Errorf("load tls files: %v", err)
+	}
+	return f.clientConfig(), nil
+}
+
+//按地址的 scheme 监听 https 使用 TLS clientAuth 表示该端口在启用 mTLS 时要求客户端证书
+func listenAndServe(addr string, handler http.Handler, c TLSConfig, clientAuth bool) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: u.Host, Handler: handler}
+	if u.Scheme != "https" {
+		return srv.ListenAndServe()
+	}
+	if !c.Enabled() {
+		return fmt.Errorf("%s: tls.cert_file is required for https", addr)
+	}
+	f, err := loadTLSFiles(c)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = f.serverConfig(clientAuth && c.ClientAuth)
+	return srv.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,234 @@
+package disk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//本地磁盘存储 数据追加写入分段文件 索引保存在内存中
+//总大小超过上限时删除最早的分段 删除和覆盖只修改索引 空间随分段删除回收
+//数据只在进程运行期间有效 打开时会清空目录下已有的分段文件
+
+//条目头: key 长度 4 | value 长度 4 | version 8
+//version 为写入方提供的版本号 移回上一级存储时保留
+const headerSize = 16
+
+const segmentExt = ".seg"
+
+//分段数量 单个分段大小为 maxBytes / segmentCount
+const segmentCount = 8
+
+type location struct {
+	seg     *segment
+	offset  int64
+	keyLen  uint32
+	valLen  uint32
+	version uint64
+}
+
+type segment struct {
+	id   int
+	file *os.File
+	size int64
+	keys map[string]struct{} //数据仍在该分段中的 key 删除分段时清理索引
+}
+
+type Store struct {
+	mu          sync.Mutex
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+	segments    []*segment //按写入顺序 最后一个为当前写入的分段
+	nextID      int
+	index       map[string]location
+	usedBytes   int64  //有效条目占用
+	onEvicted   func(key string) //删除分段时对其中的有效数据回调 回调时持有锁 不能再调用 Store 的方法
+}
+
+//maxBytes 为所有分段文件的总大小上限
+func Open(dir string, maxBytes int64, onEvicted func(key string)) (*Store, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("disk: maxBytes must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), segmentExt) {
+			if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Store{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		segmentSize: maxBytes / segmentCount,
+		index:       make(map[string]location),
+		onEvicted:   onEvicted,
+	}, nil
+}
+
+//写入数据及其版本号 超过分段大小的数据不写入
+//同一个 key 的版本号与数据一一对应 DelIfVersion 据此判断数据是否被覆盖
+func (s *Store) Put(key string, value []byte, version uint64) error {
+	size := int64(headerSize + len(key) + len(value))
+	if size > s.segmentSize {
+		return fmt.Errorf("disk: entry of %d bytes exceeds segment size", size)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.del(key)
+	seg, err := s.writable(size)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(value)))
+	binary.LittleEndian.PutUint64(buf[8:], version)
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+		return err
+	}
+	s.index[key] = location{seg: seg, offset: seg.size, keyLen: uint32(len(key)), valLen: uint32(len(value)), version: version}
+	seg.size += size
+	seg.keys[key] = struct{}{}
+	s.usedBytes += size
+	return nil
+}
+
+//返回可以写入 size 字节的分段 必要时新建分段并删除最早的分段
+func (s *Store) writable(size int64) (*segment, error) {
+	if n := len(s.segments); n > 0 && s.segments[n-1].size+size <= s.segmentSize {
+		return s.segments[n-1], nil
+	}
+	for len(s.segments) >= segmentCount {
+		s.dropOldest()
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("%06d%s", s.nextID, segmentExt)), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{id: s.nextID, file: f, keys: make(map[string]struct{})}
+	s.nextID++
+	s.segments = append(s.segments, seg)
+	return seg, nil
+}
+
+func (s *Store) dropOldest() {
+	seg := s.segments[0]
+	s.segments = s.segments[1:]
+	for key := range seg.keys {
+		loc := s.index[key]
+		delete(s.index, key)
+		s.usedBytes -= int64(headerSize + loc.keyLen + loc.valLen)
+		if s.onEvicted != nil {
+			s.onEvicted(key)
+		}
+	}
+	seg.file.Close()
+	os.Remove(seg.file.Name())
+}
+
+func (s *Store) Get(key string) (value []byte, ok bool, err error) {
+	value, _, ok, err = s.GetWithVersion(key)
+	return value, ok, err
+}
+
+//同时返回写入时的版本号
+func (s *Store) GetWithVersion(key string) (value []byte, version uint64, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, 0, false, nil
+	}
+	value = make([]byte, loc.valLen)
+	if _, err := loc.seg.file.ReadAt(value, loc.offset+headerSize+int64(loc.keyLen)); err != nil {
+		return nil, 0, false, err
+	}
+	return value, loc.version, true, nil
+}
+
+//key 存在 不读取文件
+func (s *Store) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.index[key]
+	return ok
+}
+
+func (s *Store) Del(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.del(key)
+}
+
+//版本号一致时删除 期间被覆盖或删除时返回 false
+func (s *Store) DelIfVersion(key string, version uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if loc, ok := s.index[key]; !ok || loc.version != version {
+		return false
+	}
+	return s.del(key)
+}
+
+func (s *Store) del(key string) bool {
+	loc, ok := s.index[key]
+	if !ok {
+		return false
+	}
+	delete(s.index, key)
+	delete(loc.seg.keys, key)
+	s.usedBytes -= int64(headerSize + loc.keyLen + loc.valLen)
+	return true
+}
+
+//返回所有 key 不保证顺序
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.index)
+}
+
+//有效数据占用的字节数
+func (s *Store) UsedBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usedBytes
+}
+
+//关闭并删除所有分段文件
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvicted = nil
+	for len(s.segments) > 0 {
+		s.dropOldest()
+	}
+	return nil
+}
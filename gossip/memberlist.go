@@ -0,0 +1,465 @@
+package gossip
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+//SWIM 风格的成员管理 通过 ping/ping-req 探测节点 成员变化随消息捎带传播
+
+type State string
+
+const (
+	StateAlive   State = "alive"
+	StateSuspect State = "suspect"
+	StateDead    State = "dead"
+)
+
+type MessageType string
+
+const (
+	msgPing    MessageType = "ping"
+	msgPingReq MessageType = "ping-req"
+	msgJoin    MessageType = "join"
+	msgAck     MessageType = "ack"
+	msgNack    MessageType = "nack"
+)
+
+//成员状态变更
+type Update struct {
+	Name        string `json:"name"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"incarnation"`
+}
+
+type Message struct {
+	Type    MessageType `json:"type"`
+	From    string      `json:"from"`
+	Target  string      `json:"target,omitempty"` //ping-req 的探测目标
+	Updates []Update    `json:"updates,omitempty"`
+}
+
+type Config struct {
+	Name             string        //自身地址 也是成员名
+	Transport        Transport     //消息传输
+	ProbeInterval    time.Duration //探测间隔
+	ProbeTimeout     time.Duration //单次探测超时
+	IndirectChecks   int           //直接探测失败后通过多少个成员间接探测
+	SuspicionTimeout time.Duration //suspect 多久后判定为 dead
+	RetransmitMult   int           //每条变更的传播次数系数
+	MaxPiggyback     int           //每条消息最多捎带的变更数
+	OnChange         func(members []string)
+}
+
+func DefaultConfig(name string, t Transport) Config {
+	return Config{
+		Name:             name,
+		Transport:        t,
+		ProbeInterval:    time.Second,
+		ProbeTimeout:     500 * time.Millisecond,
+		IndirectChecks:   3,
+		SuspicionTimeout: 5 * time.Second,
+		RetransmitMult:   4,
+		MaxPiggyback:     16,
+	}
+}
+
+type member struct {
+	state       State
+	incarnation uint64
+	suspectAt   time.Time
+}
+
+type broadcast struct {
+	update    Update
+	transmits int
+}
+
+type Memberlist struct {
+	cfg        Config
+	mu         sync.Mutex
+	members    map[string]*member
+	queue      []*broadcast
+	probeOrder []string
+	probeIndex int
+	notifyMu   sync.Mutex //保证回调按顺序执行
+	lastAlive  []string
+	seeds      []string
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+//创建并开始探测
+func Create(cfg Config) (*Memberlist, error) {
+	if cfg.Name == "" || cfg.Transport == nil {
+		return nil, fmt.Errorf("gossip: name and transport are required")
+	}
+	l := &Memberlist{
+		cfg:     cfg,
+		members: map[string]*member{cfg.Name: {state: StateAlive}},
+		stop:    make(chan struct{}),
+	}
+	cfg.Transport.SetHandler(l.handle)
+	l.notify()
+	go l.probeLoop()
+	return l, nil
+}
+
+//通过种子节点加入集群 返回成功联系上的种子数
+func (l *Memberlist) Join(seeds []string) (int, error) {
+	l.mu.Lock()
+	l.seeds = seeds
+	l.mu.Unlock()
+
+	n := 0
+	var lastErr error
+	for _, seed := range seeds {
+		if seed == "" || seed == l.cfg.Name {
+			continue
+		}
+		reply, err := l.call(seed, Message{Type: msgJoin})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		l.merge(reply.From, reply.Updates, true)
+		n++
+	}
+	if n == 0 && lastErr != nil {
+		return 0, lastErr
+	}
+	return n, nil
+}
+
+//存活的成员 包括 suspect 按字典序
+func (l *Memberlist) Members() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.aliveLocked()
+}
+
+//所有成员状态
+func (l *Memberlist) States() map[string]State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	states := make(map[string]State, len(l.members))
+	for name, m := range l.members {
+		states[name] = m.state
+	}
+	return states
+}
+
+//主动离开 通知其他成员后停止
+func (l *Memberlist) Leave() {
+	l.mu.Lock()
+	self := l.members[l.cfg.Name]
+	self.incarnation++
+	self.state = StateDead
+	u := Update{Name: l.cfg.Name, State: StateDead, Incarnation: self.incarnation}
+	targets := l.aliveLocked()
+	l.mu.Unlock()
+
+	for _, t := range targets {
+		if t != l.cfg.Name {
+			l.send(t, Message{Type: msgPing, Updates: []Update{u}})
+		}
+	}
+	l.Shutdown()
+}
+
+func (l *Memberlist) Shutdown() {
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+func (l *Memberlist) aliveLocked() []string {
+	names := make([]string, 0, len(l.members))
+	for name, m := range l.members {
+		if m.state != StateDead {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *Memberlist) probeLoop() {
+	ticker := time.NewTicker(l.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.expireSuspects()
+			if target := l.nextTarget(); target != "" {
+				l.probe(target)
+			} else {
+				l.rejoin()
+			}
+		}
+	}
+}
+
+//没有其他存活成员时重新联系种子节点 用于网络分区恢复
+func (l *Memberlist) rejoin() {
+	l.mu.Lock()
+	seeds := l.seeds
+	l.mu.Unlock()
+	for _, seed := range seeds {
+		if seed == "" || seed == l.cfg.Name {
+			continue
+		}
+		if reply, err := l.call(seed, Message{Type: msgJoin}); err == nil {
+			l.merge(reply.From, reply.Updates, true)
+			return
+		}
+	}
+}
+
+//轮询探测目标 每轮打乱顺序
+func (l *Memberlist) nextTarget() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		for l.probeIndex < len(l.probeOrder) {
+			name := l.probeOrder[l.probeIndex]
+			l.probeIndex++
+			if m, ok := l.members[name]; ok && m.state != StateDead && name != l.cfg.Name {
+				return name
+			}
+		}
+		l.probeOrder = l.aliveLocked()
+		rand.Shuffle(len(l.probeOrder), func(i, j int) {
+			l.probeOrder[i], l.probeOrder[j] = l.probeOrder[j], l.probeOrder[i]
+		})
+		l.probeIndex = 0
+	}
+	return ""
+}
+
+func (l *Memberlist) probe(target string) {
+	if _, err := l.call(target, Message{Type: msgPing}); err == nil {
+		return
+	}
+
+	//间接探测
+	l.mu.Lock()
+	var helpers []string
+	for _, name := range l.aliveLocked() {
+		if name != l.cfg.Name && name != target {
+			helpers = append(helpers, name)
+		}
+	}
+	l.mu.Unlock()
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	if len(helpers) > l.cfg.IndirectChecks {
+		helpers = helpers[:l.cfg.IndirectChecks]
+	}
+
+	acked := make(chan bool, len(helpers))
+	for _, h := range helpers {
+		go func(h string) {
+			reply, err := l.call(h, Message{Type: msgPingReq, Target: target})
+			acked <- err == nil && reply.Type == msgAck
+		}(h)
+	}
+	for range helpers {
+		if <-acked {
+			return
+		}
+	}
+
+	l.mu.Lock()
+	m, ok := l.members[target]
+	if ok && m.state == StateAlive {
+		l.applyLocked(Update{Name: target, State: StateSuspect, Incarnation: m.incarnation})
+	}
+	l.mu.Unlock()
+	l.notify()
+}
+
+func (l *Memberlist) expireSuspects() {
+	l.mu.Lock()
+	now := time.Now()
+	for name, m := range l.members {
+		if m.state == StateSuspect && now.Sub(m.suspectAt) >= l.cfg.SuspicionTimeout {
+			l.applyLocked(Update{Name: name, State: StateDead, Incarnation: m.incarnation})
+		}
+	}
+	l.mu.Unlock()
+	l.notify()
+}
+
+//处理收到的消息
+func (l *Memberlist) handle(m Message) Message {
+	l.merge(m.From, m.Updates, false)
+	switch m.Type {
+	case msgJoin:
+		l.mu.Lock()
+		updates := make([]Update, 0, len(l.members))
+		for name, mem := range l.members {
+			updates = append(updates, Update{Name: name, State: mem.state, Incarnation: mem.incarnation})
+		}
+		l.mu.Unlock()
+		return Message{Type: msgAck, From: l.cfg.Name, Updates: updates}
+	case msgPingReq:
+		if _, err := l.call(m.Target, Message{Type: msgPing}); err != nil {
+			return l.message(msgNack)
+		}
+	}
+	return l.message(msgAck)
+}
+
+//发送消息 捎带自身状态和待传播的变更
+func (l *Memberlist) call(addr string, m Message) (Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.cfg.ProbeTimeout)
+	defer cancel()
+	out := l.message(m.Type)
+	out.Target = m.Target
+	out.Updates = append(m.Updates, out.Updates...)
+	reply, err := l.cfg.Transport.Call(ctx, addr, out)
+	if err != nil {
+		return reply, err
+	}
+	if m.Type != msgJoin {
+		l.merge(reply.From, reply.Updates, false)
+	}
+	return reply, nil
+}
+
+func (l *Memberlist) send(addr string, m Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.cfg.ProbeTimeout)
+	defer cancel()
+	l.cfg.Transport.Call(ctx, addr, m)
+}
+
+func (l *Memberlist) message(t MessageType) Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	self := l.members[l.cfg.Name]
+	updates := []Update{{Name: l.cfg.Name, State: self.state, Incarnation: self.incarnation}}
+
+	limit := l.retransmitLimitLocked()
+	kept := l.queue[:0]
+	for _, b := range l.queue {
+		if len(updates) <= l.cfg.MaxPiggyback {
+			updates = append(updates, b.update)
+			b.transmits++
+		}
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	l.queue = kept
+	return Message{Type: t, From: l.cfg.Name, Updates: updates}
+}
+
+func (l *Memberlist) retransmitLimitLocked() int {
+	return l.cfg.RetransmitMult * int(math.Ceil(math.Log10(float64(len(l.members)+1))))
+}
+
+//from 为直接通信的对端 其自身的 alive 状态可以直接采信
+//full 为 true 表示对端的完整成员表(join 回复) 可以复活本地判定为 dead 的成员
+func (l *Memberlist) merge(from string, updates []Update, full bool) {
+	if len(updates) == 0 {
+		return
+	}
+	l.mu.Lock()
+	for _, u := range updates {
+		if m, ok := l.members[u.Name]; ok && u.State == StateAlive && m.state != StateAlive &&
+			u.Incarnation >= m.incarnation && (u.Name == from || full && m.state == StateDead) {
+			m.state = StateAlive
+			m.incarnation = u.Incarnation
+			l.enqueueLocked(u)
+			continue
+		}
+		l.applyLocked(u)
+	}
+	l.mu.Unlock()
+	l.notify()
+}
+
+//按 SWIM 规则合并变更 返回是否生效
+func (l *Memberlist) applyLocked(u Update) bool {
+	if u.Name == "" {
+		return false
+	}
+	if u.Name == l.cfg.Name {
+		self := l.members[l.cfg.Name]
+		//被怀疑或宣告死亡时提高 incarnation 反驳
+		if u.State != StateAlive && self.state == StateAlive && u.Incarnation >= self.incarnation {
+			self.incarnation = u.Incarnation + 1
+			l.enqueueLocked(Update{Name: l.cfg.Name, State: StateAlive, Incarnation: self.incarnation})
+		}
+		return false
+	}
+
+	m, ok := l.members[u.Name]
+	if !ok {
+		if u.State == StateDead {
+			return false
+		}
+		m = &member{state: u.State, incarnation: u.Incarnation, suspectAt: time.Now()}
+		l.members[u.Name] = m
+		l.enqueueLocked(u)
+		return true
+	}
+
+	switch u.State {
+	case StateAlive:
+		if u.Incarnation <= m.incarnation {
+			return false
+		}
+	case StateSuspect:
+		if u.Incarnation < m.incarnation || m.state != StateAlive && u.Incarnation == m.incarnation {
+			return false
+		}
+		m.suspectAt = time.Now()
+	case StateDead:
+		if u.Incarnation < m.incarnation || m.state == StateDead {
+			return false
+		}
+	default:
+		return false
+	}
+	m.state = u.State
+	m.incarnation = u.Incarnation
+	l.enqueueLocked(u)
+	return true
+}
+
+func (l *Memberlist) enqueueLocked(u Update) {
+	for i, b := range l.queue {
+		if b.update.Name == u.Name {
+			l.queue[i] = &broadcast{update: u}
+			return
+		}
+	}
+	l.queue = append(l.queue, &broadcast{update: u})
+}
+
+//存活成员变化时回调
+func (l *Memberlist) notify() {
+	l.notifyMu.Lock()
+	defer l.notifyMu.Unlock()
+
+	l.mu.Lock()
+	alive := l.aliveLocked()
+	changed := len(alive) != len(l.lastAlive)
+	for i := 0; !changed && i < len(alive); i++ {
+		changed = alive[i] != l.lastAlive[i]
+	}
+	if changed {
+		l.lastAlive = alive
+	}
+	l.mu.Unlock()
+
+	if changed && l.cfg.OnChange != nil {
+		l.cfg.OnChange(alive)
+	}
+}
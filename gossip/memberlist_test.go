@@ -0,0 +1,184 @@
+package gossip
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//探测间隔较短的配置 interval 为 0 时不主动探测
+func testConfig(net *InProcNetwork, name string, interval time.Duration) Config {
+	cfg := DefaultConfig(name, net.Transport(name))
+	cfg.ProbeInterval = interval
+	if interval == 0 {
+		cfg.ProbeInterval = time.Hour
+	}
+	cfg.ProbeTimeout = 20 * time.Millisecond
+	cfg.SuspicionTimeout = 150 * time.Millisecond
+	return cfg
+}
+
+func create(t *testing.T, cfg Config) *Memberlist {
+	t.Helper()
+	l, err := Create(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func (l *Memberlist) incarnationOf(name string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.members[name].incarnation
+}
+
+//所有节点通过同一个种子加入后成员表一致
+func TestJoinConvergence(t *testing.T) {
+	net := NewInProcNetwork()
+	var names []string
+	var lists []*Memberlist
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("node%d", i)
+		names = append(names, name)
+		l := create(t, testConfig(net, name, 10*time.Millisecond))
+		defer l.Shutdown()
+		lists = append(lists, l)
+	}
+	for _, l := range lists[1:] {
+		if n, err := l.Join([]string{"node0", "missing"}); n != 1 || err != nil {
+			t.Fatalf("Join = %d, %v; want 1, nil", n, err)
+		}
+	}
+
+	for _, l := range lists {
+		l := l
+		waitFor(t, l.cfg.Name+" to see every member", func() bool {
+			return reflect.DeepEqual(l.Members(), names)
+		})
+	}
+
+	//种子都不可达时返回错误
+	l := create(t, testConfig(net, "lonely", 0))
+	defer l.Shutdown()
+	if _, err := l.Join([]string{"missing"}); err == nil {
+		t.Fatal("Join with unreachable seeds: want error")
+	}
+}
+
+//节点断开后先被判定为 suspect 超过 SuspicionTimeout 后为 dead 并通知 OnChange
+func TestFailureDetection(t *testing.T) {
+	net := NewInProcNetwork()
+	changes := make(chan []string, 100)
+	cfg := testConfig(net, "a", 10*time.Millisecond)
+	cfg.OnChange = func(members []string) { changes <- members }
+	a := create(t, cfg)
+	defer a.Shutdown()
+	b := create(t, testConfig(net, "b", 10*time.Millisecond))
+	defer b.Shutdown()
+	c := create(t, testConfig(net, "c", 10*time.Millisecond))
+	defer c.Shutdown()
+	b.Join([]string{"a"})
+	c.Join([]string{"a"})
+	waitFor(t, "a to see every member", func() bool { return len(a.Members()) == 3 })
+
+	net.SetDown("c", true)
+	suspectSeen := false
+	waitFor(t, "c to be declared dead", func() bool {
+		switch a.States()["c"] {
+		case StateSuspect:
+			suspectSeen = true
+		case StateDead:
+			return true
+		}
+		return false
+	})
+	if !suspectSeen {
+		t.Fatal("c went dead without being suspected first")
+	}
+	waitFor(t, "b to learn c is dead", func() bool { return b.States()["c"] == StateDead })
+	if got := a.Members(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("Members = %v; want [a b]", got)
+	}
+
+	var last []string
+	for len(changes) > 0 {
+		last = <-changes
+	}
+	if !reflect.DeepEqual(last, []string{"a", "b"}) {
+		t.Fatalf("last OnChange = %v; want [a b]", last)
+	}
+}
+
+//被怀疑的节点提高 incarnation 反驳 旧 incarnation 的 alive 不能覆盖 suspect
+func TestRefuteSuspicion(t *testing.T) {
+	net := NewInProcNetwork()
+	a := create(t, testConfig(net, "a", 0))
+	defer a.Shutdown()
+	b := create(t, testConfig(net, "b", 0))
+	defer b.Shutdown()
+	c := create(t, testConfig(net, "c", 0))
+	defer c.Shutdown()
+	b.Join([]string{"a"})
+	c.Join([]string{"a"})
+
+	//c 转告 b 怀疑 a
+	b.handle(Message{Type: msgPing, From: "c", Updates: []Update{{Name: "a", State: StateSuspect}}})
+	if got := b.States()["a"]; got != StateSuspect {
+		t.Fatalf("b sees a as %s; want suspect", got)
+	}
+	b.handle(Message{Type: msgPing, From: "c", Updates: []Update{{Name: "a", State: StateAlive}}})
+	if got := b.States()["a"]; got != StateSuspect {
+		t.Fatalf("alive with the same incarnation from a third node: b sees a as %s; want suspect", got)
+	}
+
+	//a 收到对自身的怀疑后提高 incarnation 并在回复中捎带
+	reply, err := net.Transport("c").Call(context.Background(), "a", Message{Type: msgPing, From: "c",
+		Updates: []Update{{Name: "a", State: StateSuspect}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.incarnationOf("a"); got != 1 {
+		t.Fatalf("a incarnation after refuting = %d; want 1", got)
+	}
+	if a.States()["a"] != StateAlive {
+		t.Fatal("a changed its own state")
+	}
+	refuted := false
+	for _, u := range reply.Updates {
+		if u.Name == "a" && u.State == StateAlive && u.Incarnation == 1 {
+			refuted = true
+		}
+	}
+	if !refuted {
+		t.Fatalf("reply %+v does not carry the refutation", reply.Updates)
+	}
+
+	//c 将反驳转告 b 后 b 恢复 a 为 alive
+	b.handle(Message{Type: msgPing, From: "c", Updates: []Update{{Name: "a", State: StateAlive, Incarnation: 1}}})
+	if got := b.States()["a"]; got != StateAlive {
+		t.Fatalf("after refutation b sees a as %s; want alive", got)
+	}
+	if got := b.incarnationOf("a"); got != 1 {
+		t.Fatalf("b has a at incarnation %d; want 1", got)
+	}
+	//更旧的怀疑不再生效
+	b.handle(Message{Type: msgPing, From: "c", Updates: []Update{{Name: "a", State: StateSuspect}}})
+	if got := b.States()["a"]; got != StateAlive {
+		t.Fatalf("stale suspicion: b sees a as %s; want alive", got)
+	}
+}
@@ -0,0 +1,141 @@
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+//消息传输 Call 发送消息并等待对方回复
+type Transport interface {
+	Call(ctx context.Context, addr string, m Message) (Message, error)
+	SetHandler(h func(m Message) Message)
+}
+
+//基于 http 的传输 消息以 json 发送到 addr+path
+type HTTPTransport struct {
+	path    string
+	client  *http.Client
+	mu      sync.RWMutex
+	handler func(m Message) Message
+}
+
+func NewHTTPTransport(path string, client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTransport{path: path, client: client}
+}
+
+func (t *HTTPTransport) Call(ctx context.Context, addr string, m Message) (Message, error) {
+	var reply Message
+	body, err := json.Marshal(m)
+	if err != nil {
+		return reply, err
+	}
+	req, err := http.NewRequest(http.MethodPost, addr+t.path, bytes.NewReader(body))
+	if err != nil {
+		return reply, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return reply, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return reply, fmt.Errorf("gossip returned:%v", res.Status)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return reply, fmt.Errorf("reading response  body: %v", err)
+	}
+	err = json.Unmarshal(data, &reply)
+	return reply, err
+}
+
+func (t *HTTPTransport) SetHandler(h func(m Message) Message) {
+	t.mu.Lock()
+	t.handler = h
+	t.mu.Unlock()
+}
+
+//挂载到节点的 http 服务上
+func (t *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.RLock()
+	h := t.handler
+	t.mu.RUnlock()
+	if h == nil {
+		http.Error(w, "gossip not started", http.StatusServiceUnavailable)
+		return
+	}
+
+	var m Message
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body, err := json.Marshal(h(m))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+//进程内网络 用于测试 可以模拟节点断开
+type InProcNetwork struct {
+	mu       sync.RWMutex
+	handlers map[string]func(m Message) Message
+	down     map[string]bool
+}
+
+func NewInProcNetwork() *InProcNetwork {
+	return &InProcNetwork{
+		handlers: make(map[string]func(m Message) Message),
+		down:     make(map[string]bool),
+	}
+}
+
+//返回地址为 addr 的传输
+func (n *InProcNetwork) Transport(addr string) Transport {
+	return &inProcTransport{net: n, addr: addr}
+}
+
+//断开或恢复节点
+func (n *InProcNetwork) SetDown(addr string, down bool) {
+	n.mu.Lock()
+	n.down[addr] = down
+	n.mu.Unlock()
+}
+
+type inProcTransport struct {
+	net  *InProcNetwork
+	addr string
+}
+
+func (t *inProcTransport) Call(ctx context.Context, addr string, m Message) (Message, error) {
+	t.net.mu.RLock()
+	h, ok := t.net.handlers[addr]
+	down := t.net.down[addr] || t.net.down[t.addr]
+	t.net.mu.RUnlock()
+	if !ok || down {
+		return Message{}, fmt.Errorf("gossip: %s unreachable", addr)
+	}
+	if err := ctx.Err(); err != nil {
+		return Message{}, err
+	}
+	return h(m), nil
+}
+
+func (t *inProcTransport) SetHandler(h func(m Message) Message) {
+	t.net.mu.Lock()
+	t.net.handlers[t.addr] = h
+	t.net.mu.Unlock()
+}
@@ -0,0 +1,225 @@
+package logger
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level:%s", s)
+}
+
+//日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+//可替换的日志接口
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	//返回附带固定字段的 Logger
+	With(fields ...Field) Logger
+}
+
+//默认实现 支持 text/json 两种格式
+type stdLogger struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  Level
+	json   bool
+	fields []Field
+}
+
+func New(w io.Writer, level Level, format string) Logger {
+	return &stdLogger{mu: new(sync.Mutex), w: w, level: level, json: format == "json"}
+}
+
+func (l *stdLogger) Debug(msg string, fields ...Field) { l.log(DebugLevel, msg, fields) }
+func (l *stdLogger) Info(msg string, fields ...Field)  { l.log(InfoLevel, msg, fields) }
+func (l *stdLogger) Warn(msg string, fields ...Field)  { l.log(WarnLevel, msg, fields) }
+func (l *stdLogger) Error(msg string, fields ...Field) { l.log(ErrorLevel, msg, fields) }
+
+func (l *stdLogger) With(fields ...Field) Logger {
+	c := *l
+	c.fields = append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+	return &c
+}
+
+func (l *stdLogger) log(level Level, msg string, fields []Field) {
+	if level < l.level {
+		return
+	}
+	all := append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+	ts := time.Now().Format(time.RFC3339Nano)
+
+	var line []byte
+	if l.json {
+		m := make(map[string]interface{}, len(all)+3)
+		for _, f := range all {
+			m[f.Key] = jsonValue(f.Value)
+		}
+		m["time"] = ts
+		m["level"] = level.String()
+		m["msg"] = msg
+		line, _ = json.Marshal(m)
+		line = append(line, '\n')
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %-5s %s", ts, strings.ToUpper(level.String()), msg)
+		for _, f := range all {
+			fmt.Fprintf(&b, " %s=%s", f.Key, textValue(f.Value))
+		}
+		b.WriteByte('\n')
+		line = []byte(b.String())
+	}
+
+	l.mu.Lock()
+	l.w.Write(line)
+	l.mu.Unlock()
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case time.Duration:
+		return x.String()
+	case fmt.Stringer:
+		return x.String()
+	}
+	return v
+}
+
+func textValue(v interface{}) string {
+	s := fmt.Sprint(jsonValue(v))
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+var (
+	mu  sync.RWMutex
+	std Logger = New(os.Stderr, InfoLevel, "text")
+)
+
+//替换全局 Logger
+func SetDefault(l Logger) {
+	mu.Lock()
+	std = l
+	mu.Unlock()
+}
+
+func Default() Logger {
+	mu.RLock()
+	defer mu.RUnlock()
+	return std
+}
+
+func Debug(msg string, fields ...Field) { Default().Debug(msg, fields...) }
+func Info(msg string, fields ...Field)  { Default().Info(msg, fields...) }
+func Warn(msg string, fields ...Field)  { Default().Warn(msg, fields...) }
+func Error(msg string, fields ...Field) { Default().Error(msg, fields...) }
+
+type ctxKey struct{}
+
+//请求 ID 透传
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+//返回附带请求 ID 的 Logger
+func FromContext(ctx context.Context) Logger {
+	if id := RequestID(ctx); id != "" {
+		return Default().With(F("request_id", id))
+	}
+	return Default()
+}
+
+//生成请求 ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//按比例采样 rate 取值 0~1
+type Sampler struct {
+	mu   sync.Mutex
+	rate float64
+}
+
+func NewSampler(rate float64) *Sampler {
+	return &Sampler{rate: rate}
+}
+
+func (s *Sampler) SetRate(rate float64) {
+	s.mu.Lock()
+	s.rate = rate
+	s.mu.Unlock()
+}
+
+func (s *Sampler) Sample() bool {
+	s.mu.Lock()
+	rate := s.rate
+	s.mu.Unlock()
+	if rate >= 1 {
+		return true
+	}
+	return rate > 0 && rand.Float64() < rate
+}
@@ -11,19 +11,41 @@ type Cache struct {
 	usedBytes int64
 	list      *list.List
 	data      map[string]*list.Element
-	onRemoved func(key string, value string)
+	onRemoved func(key string, value Value) //数据被删除或淘汰时回调
+	onEvicted func(key string, value Value, version uint64) //设置后淘汰时代替 onRemoved 调用
+	version   uint64 //全局递增版本号
+	stats     Stats
 }
 
+//统计信息快照
+type Stats struct {
+	Gets      int64 `json:"gets"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Sets      int64 `json:"sets"`
+	Deletes   int64 `json:"deletes"`
+	Evictions int64 `json:"evictions"` //内存不足被淘汰的条数
+	UsedBytes int64 `json:"used_bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+	Items     int64 `json:"items"`
+}
+
+//每条数据除 key 与 value 外的内存开销估算(64 位)
+//list.Element 48 + entry 48 + map 槽位及扩容余量约 40 + value 装箱 24
+const EntryOverhead = 160
+
 type entry struct {
-	key   string
-	value Value
+	key     string
+	value   Value
+	version uint64 //CAS 版本号
+	size    int64  //写入时计入的内存大小
 }
 
 type Value interface {
 	Len() int
 }
 
-func New(maxBytes int64, onRemoved func(key string, value string)) *Cache {
+func New(maxBytes int64, onRemoved func(key string, value Value)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
 		list:      list.New(),
@@ -32,65 +54,206 @@ func New(maxBytes int64, onRemoved func(key string, value string)) *Cache {
 	}
 }
 
+//设置淘汰回调 用于区分删除与淘汰 例如淘汰的数据写入下一级存储
+//version 为淘汰时的版本号 移回时通过 AddWithVersion 保留
+func (c *Cache) SetOnEvicted(fn func(key string, value Value, version uint64)) {
+	c.onEvicted = fn
+}
+
 func (c *Cache) Add(key string, value Value) {
-	v := &entry{key: key, value: value}
+	c.add(key, value, 0)
+}
+
+//写入从下一级存储移回的数据 保留淘汰前的版本号 之前读取的版本号仍可用于 CAS
+func (c *Cache) AddWithVersion(key string, value Value, version uint64) {
+	c.add(key, value, version)
+}
+
+//写入数据 version 为 0 时分配新版本号 返回版本号
+func (c *Cache) add(key string, value Value, version uint64) uint64 {
+	if version == 0 {
+		c.version++
+		version = c.version
+	} else if version > c.version {
+		c.version = version
+	}
+	c.stats.Sets++
+	v := &entry{key: key, value: value, version: version, size: EntrySize(key, value)}
 	if e, ok := c.data[key]; ok {
-		//更新
-		if e.Value != v {
-			kv := e.Value.(*entry)
-			//已用内存计算
-			c.usedBytes += int64(value.Len() - kv.value.Len())
-			e.Value = v
-		}
+		//更新 按记录的大小计算 同一个值原地修改后重新写入也能正确计算
+		kv := e.Value.(*entry)
+		c.usedBytes += v.size - kv.size
+		e.Value = v
 		//lru策略
 		c.list.MoveToBack(e)
 	} else {
 		elemet := c.list.PushBack(v)
 		c.data[key] = elemet
-		c.usedBytes += int64(len(key) + value.Len())
+		c.usedBytes += v.size
 	}
 	//内存不足清理数据
 	if c.maxBytes != 0 && c.usedBytes > c.maxBytes {
 		c.clearOld()
 	}
+	return v.version
 }
 
 func (c *Cache) Get(key string) (value Value, ok bool) {
+	c.stats.Gets++
 	if e, ok := c.data[key]; ok {
+		c.stats.Hits++
 		c.list.MoveToBack(e)
 		kv := e.Value.(*entry)
 		return kv.value, ok
 	}
+	c.stats.Misses++
 	return nil, ok
 }
 
+//获取数据 不影响淘汰顺序
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	if e, ok := c.data[key]; ok {
+		return e.Value.(*entry).value, ok
+	}
+	return nil, false
+}
+
+//获取数据及版本号
+func (c *Cache) GetWithVersion(key string) (value Value, version uint64, ok bool) {
+	c.stats.Gets++
+	if e, ok := c.data[key]; ok {
+		c.stats.Hits++
+		c.list.MoveToBack(e)
+		kv := e.Value.(*entry)
+		return kv.value, kv.version, ok
+	}
+	c.stats.Misses++
+	return nil, 0, false
+}
+
+//版本号一致时才写入 version 为 0 表示要求 key 不存在
+func (c *Cache) AddIfVersion(key string, value Value, version uint64) (uint64, bool) {
+	var cur uint64
+	if e, ok := c.data[key]; ok {
+		cur = e.Value.(*entry).version
+	}
+	if cur != version {
+		return cur, false
+	}
+	return c.add(key, value, 0), true
+}
+
+//key 不存在时写入
+func (c *Cache) AddIfAbsent(key string, value Value) (uint64, bool) {
+	if e, ok := c.data[key]; ok {
+		return e.Value.(*entry).version, false
+	}
+	return c.add(key, value, 0), true
+}
+
+//key 存在时写入
+func (c *Cache) AddIfPresent(key string, value Value) (uint64, bool) {
+	if _, ok := c.data[key]; !ok {
+		return 0, false
+	}
+	return c.add(key, value, 0), true
+}
+
+//版本号一致时才删除
+func (c *Cache) DelIfVersion(key string, version uint64) (bool, error) {
+	e, ok := c.data[key]
+	if !ok {
+		return false, fmt.Errorf("data not exists")
+	}
+	if e.Value.(*entry).version != version {
+		return false, nil
+	}
+	return c.Del(key)
+}
+
 func (c *Cache) Del(key string) (bool, error) {
 	e, ok := c.data[key]
 	if !ok {
 		return true, fmt.Errorf("data not exists")
 	}
 	//删除信息
+	c.stats.Deletes++
 	delete(c.data, key)
 	c.list.Remove(e)
 
 	//计算使用内存
 	kv := e.Value.(*entry)
-	c.usedBytes -= int64(len(kv.key) + kv.value.Len())
+	c.usedBytes -= kv.size
+	if c.onRemoved != nil {
+		c.onRemoved(kv.key, kv.value)
+	}
 	return true, nil
 }
 
+//返回所有 key 不保证顺序
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, len(c.data))
+	for k := range c.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+//当前数据条数
+func (c *Cache) Len() int {
+	return len(c.data)
+}
+
+//已用内存
+func (c *Cache) UsedBytes() int64 {
+	return c.usedBytes
+}
+
+//最大内存 0 表示不限制
+func (c *Cache) MaxBytes() int64 {
+	return c.maxBytes
+}
+
+//返回统计信息快照
+func (c *Cache) Stats() Stats {
+	s := c.stats
+	s.UsedBytes = c.usedBytes
+	s.MaxBytes = c.maxBytes
+	s.Items = int64(len(c.data))
+	return s
+}
+
+//一条数据计入的内存大小
+func EntrySize(key string, value Value) int64 {
+	return int64(EntryOverhead + len(key) + value.Len())
+}
+
+//淘汰最久未使用的数据 返回释放的内存 没有数据时返回 false
+func (c *Cache) RemoveOldest() (int64, bool) {
+	e := c.list.Front()
+	if e == nil {
+		return 0, false
+	}
+	kv := e.Value.(*entry)
+	delete(c.data, kv.key)
+	c.list.Remove(e)
+	c.usedBytes -= kv.size
+	c.stats.Evictions++
+	if c.onEvicted != nil {
+		c.onEvicted(kv.key, kv.value, kv.version)
+	} else if c.onRemoved != nil {
+		c.onRemoved(kv.key, kv.value)
+	}
+	return kv.size, true
+}
+
 func (c *Cache) clearOld() {
 	if c.maxBytes == 0 || c.usedBytes == 0 {
 		return
 	}
 	for c.maxBytes < c.usedBytes {
-		e := c.list.Front()
-		if e == nil {
+		if _, ok := c.RemoveOldest(); !ok {
 			panic("usedBytes is not empty but list is nil")
 		}
-		kv := e.Value.(*entry)
-		delete(c.data, kv.key)
-		c.list.Remove(e)
-		c.usedBytes -= int64(len(kv.key) + kv.value.Len())
 	}
 }
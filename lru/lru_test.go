@@ -0,0 +1,82 @@
+package lru
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+//与 arena 包的基准测试使用相同的数据量 go test -bench . ./arena ./lru
+const (
+	benchEntries   = 1000000
+	benchValueSize = 64
+)
+
+type bytesValue []byte
+
+func (b bytesValue) Len() int {
+	return len(b)
+}
+
+func benchKeys() []string {
+	keys := make([]string, benchEntries)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+//写满数据的缓存 容量为数据量的两倍 不会触发淘汰
+func benchCache(keys []string, value Value) *Cache {
+	c := New(int64(len(keys))*int64(EntryOverhead+16+value.Len())*2, nil)
+	for _, k := range keys {
+		c.Add(k, value)
+	}
+	return c
+}
+
+func BenchmarkSet(b *testing.B) {
+	keys, value := benchKeys(), bytesValue(make([]byte, benchValueSize))
+	c := benchCache(keys, value)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Add(keys[i%len(keys)], value)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	keys, value := benchKeys(), bytesValue(make([]byte, benchValueSize))
+	c := benchCache(keys, value)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkMixed(b *testing.B) {
+	keys, value := benchKeys(), bytesValue(make([]byte, benchValueSize))
+	c := benchCache(keys, value)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%10 == 0 {
+			c.Add(keys[i%len(keys)], value)
+		} else {
+			c.Get(keys[(i*7)%len(keys)])
+		}
+	}
+}
+
+//写满数据后一次完整 GC 的耗时
+func BenchmarkGC(b *testing.B) {
+	keys, value := benchKeys(), bytesValue(make([]byte, benchValueSize))
+	c := benchCache(keys, value)
+	runtime.GC()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	runtime.KeepAlive(c)
+}
@@ -2,24 +2,151 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/ylt94/mycache/config"
 	"github.com/ylt94/mycache/core"
+	"github.com/ylt94/mycache/logger"
+	"github.com/ylt94/mycache/trace"
 )
 
+const clusterReloadInterval = 2 * time.Second
+
+//进程配置 依次从默认值、配置文件、MYCACHE_ 环境变量、命令行参数加载
+type appConfig struct {
+	Type        string   `json:"type" usage:"请输入类型 master/node/gossip/peer"`
+	MasterAddr  string   `json:"master_addr" flag:"mastAddr" usage:"请输入master地址"`
+	ServiceAddr string   `json:"service_addr" flag:"srvAddr" usage:"请输入service地址"`
+	NodeAddr    string   `json:"node_addr" flag:"nodeAddr" usage:"请输入node地址"`
+	Seeds       []string `json:"seeds" usage:"gossip 模式的种子节点 逗号分隔"`
+	Nodes       []string `json:"nodes" usage:"静态节点列表 逗号分隔"`
+	ClusterFile string   `json:"cluster_file" flag:"clusterFile" usage:"集群配置文件 json/yaml 修改或收到 SIGHUP 时重新加载"`
+	Log         struct {
+		Level           string  `json:"level" flag:"logLevel" usage:"日志级别 debug/info/warn/error"`
+		Format          string  `json:"format" flag:"logFormat" usage:"日志格式 text/json"`
+		AccessLogSample float64 `json:"access_log_sample" flag:"accessLogSample" usage:"访问日志采样比例 0~1"`
+	} `json:"log"`
+	TraceOutput string `json:"trace_output" flag:"traceOutput" usage:"链路追踪输出 stdout 或文件路径 为空不输出"`
+	core.Config
+}
+
+func defaultAppConfig() *appConfig {
+	c := &appConfig{
+		Type:        "master",
+		MasterAddr:  "http://127.0.0.1:8089",
+		ServiceAddr: "http://127.0.0.1:8088",
+		NodeAddr:    "http://127.0.0.1:8100",
+		Config:      core.DefaultConfig(),
+	}
+	c.Log.Level = "info"
+	c.Log.Format = "text"
+	return c
+}
+
+func (c *appConfig) Validate() error {
+	switch c.Type {
+	case "master", "node", "gossip", "peer":
+	default:
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		return err
+	}
+	if c.Log.AccessLogSample < 0 || c.Log.AccessLogSample > 1 {
+		return fmt.Errorf("log.access_log_sample must be between 0 and 1")
+	}
+	//启用 TLS 时所有地址使用 https
+	scheme := "http://"
+	if c.TLS.Enabled() {
+		scheme = "https://"
+	}
+	addrs := append([]string{c.NodeAddr}, append(c.Seeds, c.Nodes...)...)
+	switch c.Type {
+	case "master":
+		addrs = append([]string{c.MasterAddr, c.ServiceAddr}, c.Nodes...)
+	case "node":
+		addrs = []string{c.MasterAddr, c.NodeAddr}
+	}
+	for _, addr := range addrs {
+		if !strings.HasPrefix(addr, scheme) {
+			return fmt.Errorf("address %s must start with %s", addr, scheme)
+		}
+	}
+	return c.Config.Validate()
+}
+
 func main() {
-	srvType := flag.String("type", "master", "请输入类型")
-	masterAddr := flag.String("mastAddr", "http://127.0.0.1:8089", "请输入master地址")
-	srvAddr := flag.String("srvAddr", "http://127.0.0.1:8088", "请输入master地址")
-	nodeAddr := flag.String("nodeAddr", "http://127.0.0.1:8100", "请输入node地址")
-	flag.Parse()
-	if *srvType == "master" {
-		master := core.NewMaster(*masterAddr)
-		service := core.NewService(*srvAddr, master)
+	cfg := defaultAppConfig()
+	loader := config.NewLoader(cfg, flag.CommandLine, "MYCACHE_")
+	printConfig := flag.Bool("print-config", false, "输出最终配置后退出")
+	if err := loader.Parse(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("invalid config: ", err)
+	}
+	if *printConfig {
+		config.Print(os.Stdout, cfg)
+		return
+	}
+
+	level, _ := logger.ParseLevel(cfg.Log.Level)
+	logger.SetDefault(logger.New(os.Stderr, level, cfg.Log.Format))
+	core.SetAccessLogSampleRate(cfg.Log.AccessLogSample)
+	if cfg.TraceOutput == "stdout" {
+		trace.SetExporter(trace.NewWriterExporter(os.Stdout))
+	} else if cfg.TraceOutput != "" {
+		exporter, err := trace.NewFileExporter(cfg.TraceOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trace.SetExporter(exporter)
+	}
+
+	nodes := core.ParseNodeList(strings.Join(cfg.Nodes, ","))
+	//静态节点配置 配置文件优先
+	watchNodes := func(apply func(nodes []string)) {
+		if cfg.ClusterFile != "" {
+			core.WatchClusterFile(cfg.ClusterFile, clusterReloadInterval, apply)
+		} else if len(nodes) > 0 {
+			apply(nodes)
+		}
+	}
+
+	if cfg.Type != "master" {
+		core.SetMemoryConfig(cfg.Memory)
+	}
+	if cfg.Type == "master" {
+		master := core.NewMaster(cfg.MasterAddr, cfg.Config)
+		watchNodes(master.SetStaticNodes)
+		service := core.NewService(cfg.ServiceAddr, master)
 		core.ServiceStart(service)
+	} else if cfg.Type == "gossip" {
+		nodeCache := core.NewMCache(cfg.NodeAddr, cfg.Cache, nil)
+		nodeService := core.NewNodeServer(cfg.NodeAddr, nodeCache, cfg.Config)
+		//静态节点作为种子 只在启动时加入一次
+		seedList := append(core.ParseNodeList(strings.Join(cfg.Seeds, ",")), nodes...)
+		if cfg.ClusterFile != "" {
+			fileNodes, err := core.LoadClusterFile(cfg.ClusterFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			seedList = append(seedList, fileNodes...)
+		}
+		core.GossipStart(nodeService, seedList)
+	} else if cfg.Type == "peer" {
+		nodeCache := core.NewMCache(cfg.NodeAddr, cfg.Cache, nil)
+		nodeService := core.NewNodeServer(cfg.NodeAddr, nodeCache, cfg.Config)
+		watchNodes(func(nodes []string) { nodeService.SetPeers(append(nodes, cfg.NodeAddr)...) })
+		core.PeerStart(nodeService)
 	} else {
-		nodeCache := core.NewMCache(*nodeAddr, 2<<10, nil)
-		nodeService := core.NewNodeServer(*nodeAddr, nodeCache)
-		core.ServerStart(nodeService, *masterAddr)
+		nodeCache := core.NewMCache(cfg.NodeAddr, cfg.Cache, nil)
+		nodeService := core.NewNodeServer(cfg.NodeAddr, nodeCache, cfg.Config)
+		core.ServerStart(nodeService, cfg.MasterAddr)
 	}
 
 }
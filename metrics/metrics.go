@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//默认延迟分桶 单位秒
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+//指标注册表 以 prometheus 文本格式输出
+type Registry struct {
+	mu       sync.RWMutex
+	families []*family
+	collects []func()
+}
+
+type family struct {
+	name   string
+	help   string
+	typ    string
+	label  string
+	write  func(w io.Writer, f *family)
+	series sync.Map //标签值 -> 指标
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, old := range r.families {
+		if old.name == f.name {
+			panic("metrics: duplicate metric " + f.name)
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+//每次输出指标前调用 用于一次性采集多个 CounterFunc/GaugeFunc 共用的数据
+func (r *Registry) OnCollect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collects = append(r.collects, fn)
+}
+
+//计数器
+type Counter struct {
+	bits uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&c.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+//仪表盘 可增可减
+type Gauge struct {
+	Counter
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (r *Registry) Counter(name string, help string) *Counter {
+	c := &Counter{}
+	r.register(&family{name: name, help: help, typ: "counter", write: func(w io.Writer, f *family) {
+		writeSample(w, f.name, "", "", c.Value())
+	}})
+	return c
+}
+
+func (r *Registry) Gauge(name string, help string) *Gauge {
+	g := &Gauge{}
+	r.register(&family{name: name, help: help, typ: "gauge", write: func(w io.Writer, f *family) {
+		writeSample(w, f.name, "", "", g.Value())
+	}})
+	return g
+}
+
+//采集时调用 fn 取值的计数器
+func (r *Registry) CounterFunc(name string, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, typ: "counter", write: func(w io.Writer, f *family) {
+		writeSample(w, f.name, "", "", fn())
+	}})
+}
+
+//采集时调用 fn 取值的仪表盘
+func (r *Registry) GaugeFunc(name string, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, typ: "gauge", write: func(w io.Writer, f *family) {
+		writeSample(w, f.name, "", "", fn())
+	}})
+}
+
+//带一个标签的计数器
+type CounterVec struct {
+	f *family
+}
+
+func (r *Registry) CounterVec(name string, help string, label string) *CounterVec {
+	f := &family{name: name, help: help, typ: "counter", label: label}
+	f.write = func(w io.Writer, f *family) {
+		f.each(func(value string, m interface{}) {
+			writeSample(w, f.name, f.label, value, m.(*Counter).Value())
+		})
+	}
+	r.register(f)
+	return &CounterVec{f: f}
+}
+
+func (v *CounterVec) With(value string) *Counter {
+	m, _ := v.f.series.LoadOrStore(value, &Counter{})
+	return m.(*Counter)
+}
+
+//直方图
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     Counter
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(v)
+}
+
+func (h *Histogram) write(w io.Writer, name string, label string, value string) {
+	var cum uint64
+	for i, b := range h.buckets {
+		cum += atomic.LoadUint64(&h.counts[i])
+		writeBucket(w, name, label, value, strconv.FormatFloat(b, 'g', -1, 64), cum)
+	}
+	count := atomic.LoadUint64(&h.count)
+	writeBucket(w, name, label, value, "+Inf", count)
+	writeSample(w, name+"_sum", label, value, h.sum.Value())
+	writeSample(w, name+"_count", label, value, float64(count))
+}
+
+//带一个标签的直方图
+type HistogramVec struct {
+	f       *family
+	buckets []float64
+}
+
+func (r *Registry) HistogramVec(name string, help string, label string, buckets []float64) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	f := &family{name: name, help: help, typ: "histogram", label: label}
+	f.write = func(w io.Writer, f *family) {
+		f.each(func(value string, m interface{}) {
+			m.(*Histogram).write(w, f.name, f.label, value)
+		})
+	}
+	r.register(f)
+	return &HistogramVec{f: f, buckets: buckets}
+}
+
+func (v *HistogramVec) With(value string) *Histogram {
+	if m, ok := v.f.series.Load(value); ok {
+		return m.(*Histogram)
+	}
+	m, _ := v.f.series.LoadOrStore(value, newHistogram(v.buckets))
+	return m.(*Histogram)
+}
+
+//按标签值排序遍历
+func (f *family) each(fn func(value string, m interface{})) {
+	var values []string
+	f.series.Range(func(k, _ interface{}) bool {
+		values = append(values, k.(string))
+		return true
+	})
+	sort.Strings(values)
+	for _, v := range values {
+		m, _ := f.series.Load(v)
+		fn(v, m)
+	}
+}
+
+//输出所有指标
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.RLock()
+	families := make([]*family, len(r.families))
+	copy(families, r.families)
+	collects := r.collects
+	r.mu.RUnlock()
+
+	for _, fn := range collects {
+		fn()
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escape(f.help, false))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		f.write(bw, f)
+	}
+	return bw.Flush()
+}
+
+func writeSample(w io.Writer, name string, label string, value string, v float64) {
+	if label == "" {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+		return
+	}
+	fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", name, label, escape(value, true), formatFloat(v))
+}
+
+func writeBucket(w io.Writer, name string, label string, value string, le string, n uint64) {
+	if label == "" {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, le, n)
+		return
+	}
+	fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"%s\"} %d\n", name, label, escape(value, true), le, n)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escape(s string, quote bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quote {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
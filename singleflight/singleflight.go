@@ -1,43 +1,182 @@
 package singleflight
 
 import (
+	"context"
+	"fmt"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
+//fn panic 时所有等待方收到的值 Do/DoContext 会重新 panic DoChan 通过 Result.Err 返回
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: panic: %v\n\n%s", p.Value, p.Stack)
+}
+
+//DoChan 的返回结果 Shared 表示结果被多个调用方共享
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool
+}
+
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done     chan struct{} //fn 执行完成 超时或被 Forget 后仍会关闭
+	val      interface{}
+	err      error
+	dups     int  //合并的调用数
+	waiters  int  //仍在等待结果的调用方 全部放弃时取消 fn
+	finished bool //结果已确定 之后 fn 返回的结果被丢弃
+	chans    []chan<- Result
+	cancel   context.CancelFunc
 }
 
 type Group struct {
+	//每个 key 执行 fn 的超时时间 0 不限制
+	//超时后取消 fn 的 context 所有等待方返回 context.DeadlineExceeded 不再等待 fn 返回
+	Timeout time.Duration
+	//按 key 返回超时时间 返回值大于 0 时代替 Timeout 调用时持有锁 不能调用 Group 的方法
+	KeyTimeout func(key string) time.Duration
+
 	mu sync.Mutex
 	m  map[string]*call
 }
 
+//同一个 key 同时只执行一次 fn 其余调用等待并共享结果
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	v, err, _ := g.DoContext(context.Background(), key, func(context.Context) (interface{}, error) {
+		return fn()
+	})
+	return v, err
+}
+
+//ctx 结束时当前调用方返回 ctx.Err() 其余调用方继续等待
+//fn 的 context 与调用方无关 只在超时或所有调用方都放弃时取消
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
+	c := g.join(key, fn)
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		if p, ok := c.err.(*PanicError); ok {
+			panic(p)
+		}
+		return c.val, c.err, c.dups > 0
+	case <-ctx.Done():
+		g.leave(key, c)
+		return nil, ctx.Err(), false
+	}
+}
+
+//返回接收结果的 channel 调用方不能放弃等待
+func (g *Group) DoChan(key string, fn func(ctx context.Context) (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	c := g.join(key, fn)
+	c.chans = append(c.chans, ch)
+	g.mu.Unlock()
+	return ch
+}
+
+//之后的调用重新执行 fn 正在等待的调用方仍然收到本次结果
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+}
+
+//加入 key 正在执行的调用 没有时发起新的调用 需持有锁
+func (g *Group) join(key string, fn func(ctx context.Context) (interface{}, error)) *call {
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
-
 	if c, ok := g.m[key]; ok {
-		g.mu.Unlock()
-		c.wg.Wait()         // 如果请求正在进行中，则等待
-		return c.val, c.err // 请求结束，返回结果
+		c.dups++
+		c.waiters++
+		return c
 	}
 
-	c := new(call)
-	c.wg.Add(1)  // 发起请求前加锁
-	g.m[key] = c // 添加到 g.m，表明 key 已经有对应的请求在处理
-	g.mu.Unlock()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	timeout := g.timeout(key)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	c := &call{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.m[key] = c
+	go g.call(ctx, key, c, fn)
+	if timeout > 0 {
+		go func() {
+			<-ctx.Done()
+			if ctx.Err() == context.DeadlineExceeded {
+				g.finish(key, c, nil, ctx.Err())
+			}
+		}()
+	}
+	return c
+}
+
+func (g *Group) timeout(key string) time.Duration {
+	if g.KeyTimeout != nil {
+		if d := g.KeyTimeout(key); d > 0 {
+			return d
+		}
+	}
+	return g.Timeout
+}
 
-	c.val, c.err = fn() // 调用 fn，发起请求
-	c.wg.Done()         // 请求结束
+func (g *Group) call(ctx context.Context, key string, c *call, fn func(ctx context.Context) (interface{}, error)) {
+	var val interface{}
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		g.finish(key, c, val, err)
+	}()
+	val, err = fn(ctx)
+}
 
+//确定调用结果 只有第一次生效
+func (g *Group) finish(key string, c *call, val interface{}, err error) {
 	g.mu.Lock()
-	delete(g.m, key) // 更新 g.m
+	if c.finished {
+		g.mu.Unlock()
+		return
+	}
+	c.finished = true
+	c.val, c.err = val, err
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
 	g.mu.Unlock()
 
-	return c.val, c.err
+	c.cancel()
+	close(c.done)
+	for _, ch := range c.chans {
+		ch <- Result{Val: c.val, Err: c.err, Shared: c.dups > 0}
+	}
+}
+
+//调用方放弃等待 所有调用方都放弃时取消 fn
+func (g *Group) leave(key string, c *call) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c.waiters--
+	if c.waiters > 0 || c.finished {
+		return
+	}
+	c.finished = true
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+	c.cancel()
 }
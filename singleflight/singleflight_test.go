@@ -0,0 +1,328 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Fatalf("Do = %v, %v; want bar, nil", v, err)
+	}
+}
+
+func TestDoErr(t *testing.T) {
+	var g Group
+	someErr := errors.New("some error")
+	v, err := g.Do("key", func() (interface{}, error) {
+		return nil, someErr
+	})
+	if err != someErr || v != nil {
+		t.Fatalf("Do = %v, %v; want nil, %v", v, err, someErr)
+	}
+}
+
+//并发调用只执行一次 fn 只有合并的调用 shared 为 true
+func TestDoContextShared(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var calls int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "v", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	shared := make([]bool, n)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _, shared[0] = g.DoContext(context.Background(), "key", fn)
+	}()
+	<-started
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err, s := g.DoContext(context.Background(), "key", fn)
+			if v != "v" || err != nil {
+				t.Errorf("DoContext = %v, %v; want v, nil", v, err)
+			}
+			shared[i] = s
+		}(i)
+	}
+	waitDups(t, &g, "key", n-1)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("fn called %d times; want 1", got)
+	}
+	for i, s := range shared {
+		if !s {
+			t.Errorf("caller %d: shared = false; want true", i)
+		}
+	}
+
+	_, _, s := g.DoContext(context.Background(), "key", fn)
+	if s {
+		t.Fatal("single call: shared = true; want false")
+	}
+}
+
+//fn panic 时 Do 的所有调用方重新 panic DoChan 通过 Result.Err 返回
+func TestPanicPropagation(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		panic("boom")
+	}
+
+	const n = 3
+	panics := make(chan interface{}, n)
+	var wg sync.WaitGroup
+	doPanic := func() {
+		defer wg.Done()
+		defer func() { panics <- recover() }()
+		g.DoContext(context.Background(), "key", fn)
+	}
+	wg.Add(1)
+	go doPanic()
+	<-started
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go doPanic()
+	}
+	ch := g.DoChan("key", fn)
+	waitDups(t, &g, "key", n)
+	close(release)
+	wg.Wait()
+	close(panics)
+
+	for r := range panics {
+		p, ok := r.(*PanicError)
+		if !ok {
+			t.Fatalf("recovered %T %v; want *PanicError", r, r)
+		}
+		if p.Value != "boom" || len(p.Stack) == 0 {
+			t.Fatalf("PanicError = %v; want value boom with stack", p.Value)
+		}
+	}
+	res := <-ch
+	if p, ok := res.Err.(*PanicError); !ok || p.Value != "boom" {
+		t.Fatalf("DoChan Err = %v; want *PanicError boom", res.Err)
+	}
+	if !res.Shared {
+		t.Fatal("DoChan Shared = false; want true")
+	}
+}
+
+//所有调用方都放弃时取消 fn 的 context
+func TestAllWaitersLeaveCancelsFn(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	fnErr := make(chan error, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		fnErr <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err, _ := g.DoContext(ctx1, "key", fn)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, err, _ := g.DoContext(ctx2, "key", fn)
+		errs <- err
+	}()
+	waitDups(t, &g, "key", 1)
+
+	//只有一个调用方离开时 fn 继续执行
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("first caller err = %v; want context.Canceled", err)
+	}
+	select {
+	case err := <-fnErr:
+		t.Fatalf("fn cancelled with %v while a caller is still waiting", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel2()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("second caller err = %v; want context.Canceled", err)
+	}
+	select {
+	case err := <-fnErr:
+		if err != context.Canceled {
+			t.Fatalf("fn ctx err = %v; want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fn not cancelled after all callers left")
+	}
+}
+
+//执行期间 Forget 后的调用重新执行 fn 之前的调用方仍收到原来的结果
+func TestForgetMidFlight(t *testing.T) {
+	var g Group
+	release1 := make(chan struct{})
+	started1 := make(chan struct{})
+	var calls int32
+	first := make(chan interface{}, 1)
+	go func() {
+		v, _, _ := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			close(started1)
+			<-release1
+			return 1, nil
+		})
+		first <- v
+	}()
+	<-started1
+
+	g.Forget("key")
+	v, _, shared := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return 2, nil
+	})
+	if v != 2 || shared {
+		t.Fatalf("after Forget DoContext = %v, shared %v; want 2, false", v, shared)
+	}
+
+	close(release1)
+	if v := <-first; v != 1 {
+		t.Fatalf("first caller got %v; want 1", v)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("fn called %d times; want 2", got)
+	}
+	//先执行的 fn 结束时不能删除新的调用
+	g.mu.Lock()
+	n := len(g.m)
+	g.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d calls left in group; want 0", n)
+	}
+}
+
+//超时后所有调用方返回 DeadlineExceeded fn 的 context 被取消
+func TestTimeout(t *testing.T) {
+	g := Group{Timeout: 20 * time.Millisecond}
+	block := make(chan struct{})
+	defer close(block)
+
+	//不使用 context 的 fn 无法取消 调用方仍在超时后返回
+	v, err := g.Do("key", func() (interface{}, error) {
+		<-block
+		return "late", nil
+	})
+	if err != context.DeadlineExceeded || v != nil {
+		t.Fatalf("Do = %v, %v; want nil, DeadlineExceeded", v, err)
+	}
+
+	fnErr := make(chan error, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		fnErr <- ctx.Err()
+		<-block
+		return "late", nil
+	}
+	ch := g.DoChan("key2", fn)
+	v, err, _ = g.DoContext(context.Background(), "key2", fn)
+	if err != context.DeadlineExceeded || v != nil {
+		t.Fatalf("DoContext = %v, %v; want nil, DeadlineExceeded", v, err)
+	}
+	if res := <-ch; res.Err != context.DeadlineExceeded {
+		t.Fatalf("DoChan Err = %v; want DeadlineExceeded", res.Err)
+	}
+	select {
+	case err := <-fnErr:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("fn ctx err = %v; want DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fn ctx not cancelled")
+	}
+}
+
+//KeyTimeout 按 key 设置超时 返回 0 时使用 Timeout
+func TestKeyTimeout(t *testing.T) {
+	g := Group{Timeout: 100 * time.Millisecond, KeyTimeout: func(key string) time.Duration {
+		switch key {
+		case "fast":
+			return 20 * time.Millisecond
+		case "slow":
+			return 300 * time.Millisecond
+		}
+		return 0
+	}}
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	cases := []struct {
+		key      string
+		min, max time.Duration
+	}{
+		{"fast", 20 * time.Millisecond, 90 * time.Millisecond},
+		{"other", 100 * time.Millisecond, 290 * time.Millisecond},
+		{"slow", 300 * time.Millisecond, time.Second},
+	}
+	var wg sync.WaitGroup
+	for _, c := range cases {
+		wg.Add(1)
+		go func(key string, min, max time.Duration) {
+			defer wg.Done()
+			start := time.Now()
+			_, err, _ := g.DoContext(context.Background(), key, fn)
+			d := time.Since(start)
+			if err != context.DeadlineExceeded || d < min || d > max {
+				t.Errorf("%s: DoContext = %v after %v; want DeadlineExceeded within [%v, %v]", key, err, d, min, max)
+			}
+		}(c.key, c.min, c.max)
+	}
+	wg.Wait()
+}
+
+//等待 key 的调用合并了 n 个调用方
+func waitDups(t *testing.T, g *Group, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		c := g.m[key]
+		dups := 0
+		if c != nil {
+			dups = c.dups
+		}
+		g.mu.Unlock()
+		if dups >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("key %s: timed out waiting for %d duplicate calls", key, n)
+}
@@ -0,0 +1,277 @@
+package trace
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+//跨进程传递的 span 信息
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+//导出的 span 数据
+type SpanData struct {
+	Name       string                 `json:"name"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   string                 `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+type Exporter interface {
+	Export(s *SpanData)
+}
+
+//以 json 行格式写出 span
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+//写入文件 追加模式
+func NewFileExporter(path string) (Exporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterExporter(f), nil
+}
+
+func (e *writerExporter) Export(s *SpanData) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.w.Write(append(b, '\n'))
+	e.mu.Unlock()
+}
+
+var (
+	mu       sync.RWMutex
+	exporter Exporter
+)
+
+//设置全局导出器 nil 表示关闭
+func SetExporter(e Exporter) {
+	mu.Lock()
+	exporter = e
+	mu.Unlock()
+}
+
+func getExporter() Exporter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exporter
+}
+
+type Span struct {
+	mu       sync.Mutex
+	name     string
+	sc       SpanContext
+	parent   SpanID
+	start    time.Time
+	attrs    map[string]interface{}
+	err      error
+	ended    bool
+	exporter Exporter
+}
+
+func (s *Span) SpanContext() SpanContext {
+	return s.sc
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	s.mu.Lock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	defer s.mu.Unlock()
+
+	if s.exporter == nil || !s.sc.Sampled {
+		return
+	}
+	end := time.Now()
+	d := &SpanData{
+		Name:       s.name,
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		Start:      s.start,
+		End:        end,
+		Duration:   end.Sub(s.start).String(),
+		Attributes: s.attrs,
+	}
+	if s.parent.IsValid() {
+		d.ParentID = s.parent.String()
+	}
+	if s.err != nil {
+		d.Error = s.err.Error()
+	}
+	s.exporter.Export(d)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+//开启 span 父 span 来自 ctx 中的本地 span 或远端传入的上下文
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := &Span{name: name, start: time.Now(), exporter: getExporter()}
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.sc
+	} else if rc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		parent = rc
+	}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		crand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+	crand.Read(s.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+//键值载体 http header 与 grpc metadata 均可适配
+type Carrier interface {
+	Get(key string) string
+	Set(key string, value string)
+}
+
+type HeaderCarrier map[string][]string
+
+func (c HeaderCarrier) Get(key string) string {
+	if v := c[canonical(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c HeaderCarrier) Set(key string, value string) {
+	c[canonical(key)] = []string{value}
+}
+
+func canonical(key string) string {
+	return textproto.CanonicalMIMEHeaderKey(key)
+}
+
+//grpc metadata 使用小写 key
+type MetadataCarrier map[string][]string
+
+func (c MetadataCarrier) Get(key string) string {
+	if v := c[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c MetadataCarrier) Set(key string, value string) {
+	c[strings.ToLower(key)] = []string{value}
+}
+
+const traceparentKey = "traceparent"
+
+//按 W3C traceparent 格式写入当前 span
+func Inject(ctx context.Context, c Carrier) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	flags := "00"
+	if s.sc.Sampled {
+		flags = "01"
+	}
+	c.Set(traceparentKey, fmt.Sprintf("00-%s-%s-%s", s.sc.TraceID, s.sc.SpanID, flags))
+}
+
+//解析 traceparent 作为后续 span 的父 span
+func Extract(ctx context.Context, c Carrier) context.Context {
+	sc, ok := parseTraceparent(c.Get(traceparentKey))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func parseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}